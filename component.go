@@ -0,0 +1,110 @@
+package gaea
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Component is a resource owned by the application, such as a DB pool,
+// a cache, a queue consumer or a background worker.
+// Components are started in dependency order before the servers and
+// stopped in reverse order after the servers.
+type Component interface {
+	// Name returns the unique name of the component.
+	Name() string
+	// DependsOn returns the names of the components which must be started
+	// before this one.
+	DependsOn() []string
+	// Start starts the component, it must not block.
+	Start(context.Context) error
+	// Stop stops the component.
+	Stop(context.Context) error
+}
+
+// sortComponents sorts components topologically by their dependencies.
+// Components without an ordering constraint keep their registration order.
+func sortComponents(cs []Component) ([]Component, error) {
+	index := make(map[string]Component, len(cs))
+	for _, c := range cs {
+		name := c.Name()
+		if _, ok := index[name]; ok {
+			return nil, fmt.Errorf("gaea: duplicate component %q", name)
+		}
+		index[name] = c
+	}
+	for _, c := range cs {
+		for _, dep := range c.DependsOn() {
+			if _, ok := index[dep]; !ok {
+				return nil, fmt.Errorf("gaea: component %q depends on unknown component %q", c.Name(), dep)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(cs))
+	sorted := make([]Component, 0, len(cs))
+	var path []string
+
+	var visit func(c Component) error
+	visit = func(c Component) error {
+		name := c.Name()
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			// cut the path back to the start of the cycle
+			for i, n := range path {
+				if n == name {
+					path = path[i:]
+					break
+				}
+			}
+			return fmt.Errorf("gaea: component dependency cycle: %s -> %s", strings.Join(path, " -> "), name)
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range c.DependsOn() {
+			if err := visit(index[dep]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		sorted = append(sorted, c)
+		return nil
+	}
+	for _, c := range cs {
+		if err := visit(c); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// startComponents starts cs in order and returns the components which
+// have been started, so that they can be stopped if a later one fails.
+func startComponents(ctx context.Context, cs []Component) ([]Component, error) {
+	for i, c := range cs {
+		if err := c.Start(ctx); err != nil {
+			return cs[:i], fmt.Errorf("gaea: start component %q: %w", c.Name(), err)
+		}
+	}
+	return cs, nil
+}
+
+// stopComponents stops cs in reverse order and aggregates the errors.
+func stopComponents(ctx context.Context, cs []Component) error {
+	var errs []error
+	for i := len(cs) - 1; i >= 0; i-- {
+		if err := cs[i].Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("gaea: stop component %q: %w", cs[i].Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
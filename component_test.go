@@ -0,0 +1,164 @@
+package gaea
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockComponent struct {
+	name     string
+	deps     []string
+	startErr error
+	stopErr  error
+
+	lk     *sync.Mutex
+	events *[]string
+}
+
+func (c *mockComponent) Name() string        { return c.name }
+func (c *mockComponent) DependsOn() []string { return c.deps }
+
+func (c *mockComponent) Start(context.Context) error {
+	c.record("start " + c.name)
+	return c.startErr
+}
+
+func (c *mockComponent) Stop(context.Context) error {
+	c.record("stop " + c.name)
+	return c.stopErr
+}
+
+func (c *mockComponent) record(e string) {
+	if c.events == nil {
+		return
+	}
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	*c.events = append(*c.events, e)
+}
+
+func names(cs []Component) []string {
+	ns := make([]string, 0, len(cs))
+	for _, c := range cs {
+		ns = append(ns, c.Name())
+	}
+	return ns
+}
+
+func TestSortComponents(t *testing.T) {
+	cs := []Component{
+		&mockComponent{name: "worker", deps: []string{"queue", "db"}},
+		&mockComponent{name: "queue", deps: []string{"cache"}},
+		&mockComponent{name: "db"},
+		&mockComponent{name: "cache", deps: []string{"db"}},
+	}
+	sorted, err := sortComponents(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"db", "cache", "queue", "worker"}
+	if got := names(sorted); !reflect.DeepEqual(got, want) {
+		t.Errorf("sortComponents() = %v, want %v", got, want)
+	}
+}
+
+func TestSortComponentsError(t *testing.T) {
+	tests := []struct {
+		name string
+		cs   []Component
+		want string
+	}{
+		{
+			name: "cycle",
+			cs: []Component{
+				&mockComponent{name: "a", deps: []string{"b"}},
+				&mockComponent{name: "b", deps: []string{"c"}},
+				&mockComponent{name: "c", deps: []string{"b"}},
+			},
+			want: "b -> c -> b",
+		},
+		{
+			name: "unknown",
+			cs: []Component{
+				&mockComponent{name: "a", deps: []string{"b"}},
+			},
+			want: `"a" depends on unknown component "b"`,
+		},
+		{
+			name: "duplicate",
+			cs: []Component{
+				&mockComponent{name: "a"},
+				&mockComponent{name: "a"},
+			},
+			want: `duplicate component "a"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sortComponents(tt.cs)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("sortComponents() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApp_Component(t *testing.T) {
+	var (
+		lk     sync.Mutex
+		events []string
+	)
+	app := New(
+		WithName("gaea"),
+		WithSignal(),
+		WithComponent(
+			&mockComponent{name: "cache", deps: []string{"db"}, lk: &lk, events: &events},
+			&mockComponent{name: "db", lk: &lk, events: &events},
+		),
+		AfterStop(func(context.Context) error {
+			lk.Lock()
+			defer lk.Unlock()
+			events = append(events, "after stop")
+			return nil
+		}),
+	)
+	time.AfterFunc(100*time.Millisecond, func() {
+		_ = app.Stop()
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start db", "start cache", "stop cache", "stop db", "after stop"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestApp_ComponentStartError(t *testing.T) {
+	var (
+		lk     sync.Mutex
+		events []string
+	)
+	startErr := errors.New("connection refused")
+	app := New(
+		WithName("gaea"),
+		WithSignal(),
+		WithComponent(
+			&mockComponent{name: "db", lk: &lk, events: &events},
+			&mockComponent{name: "cache", deps: []string{"db"}, startErr: startErr, lk: &lk, events: &events},
+		),
+	)
+	err := app.Run()
+	if !errors.Is(err, startErr) || !strings.Contains(err.Error(), `"cache"`) {
+		t.Fatalf("Run() = %v, want start error of cache", err)
+	}
+	want := []string{"start db", "start cache", "stop db"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
@@ -3,12 +3,16 @@ package gaea
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 
+	ic "github.com/apus-run/gaea/internal/context"
 	"github.com/apus-run/gaea/registry"
 	"github.com/apus-run/gaea/server"
 )
@@ -27,6 +31,15 @@ type Gaea struct {
 	cancel   func()
 	mu       sync.Mutex
 	instance *registry.ServiceInstance
+
+	stopOnce sync.Once
+	stopErr  error
+
+	// mdMu serializes metadata updates with deregistration
+	mdMu            sync.Mutex
+	regState        atomic.Int32
+	heartbeatCancel context.CancelFunc
+	heartbeatDone   chan struct{}
 }
 
 // New create an application lifecycle manager.
@@ -45,6 +58,11 @@ func (g *Gaea) RegisterServer(servers ...server.Server) {
 	g.opts.servers = append(g.opts.servers, servers...)
 }
 
+// RegisterComponent registers components managed by the application lifecycle.
+func (g *Gaea) RegisterComponent(components ...Component) {
+	g.opts.components = append(g.opts.components, components...)
+}
+
 // ID returns app instance id.
 func (g *Gaea) ID() string { return g.opts.id }
 
@@ -55,10 +73,16 @@ func (g *Gaea) Name() string { return g.opts.name }
 func (g *Gaea) Version() string { return g.opts.version }
 
 // Metadata returns service metadata.
-func (g *Gaea) Metadata() map[string]string { return g.opts.metadata }
+func (g *Gaea) Metadata() map[string]string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.opts.metadata
+}
 
 // Endpoint returns endpoints.
 func (g *Gaea) Endpoint() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	if g.instance != nil {
 		return g.instance.Endpoints
 	}
@@ -72,6 +96,10 @@ func (g *Gaea) Run() error {
 	if err != nil {
 		return err
 	}
+	components, err := sortComponents(g.opts.components)
+	if err != nil {
+		return err
+	}
 	g.mu.Lock()
 	g.instance = instance
 	g.mu.Unlock()
@@ -84,6 +112,12 @@ func (g *Gaea) Run() error {
 		}
 	}
 
+	// start components before servers
+	started, err := startComponents(ctx, components)
+	if err != nil {
+		return errors.Join(err, g.stopComponents(started))
+	}
+
 	for _, srv := range g.opts.servers {
 		srv := srv
 		eg.Go(func() error {
@@ -101,18 +135,43 @@ func (g *Gaea) Run() error {
 	}
 	wg.Wait()
 
+	// abort stops everything started so far when the app fails to come up,
+	// it runs the same deregister and AfterStop steps as a normal shutdown.
+	abort := func(err error) error {
+		if g.RegistryState() == RegistryRegistered {
+			err = errors.Join(err, g.deregister())
+		}
+		g.cancel()
+		if werr := eg.Wait(); werr != nil && !errors.Is(werr, context.Canceled) {
+			err = errors.Join(err, werr)
+		}
+		err = errors.Join(err, g.stopComponents(started))
+		stopCtx := NewContext(g.opts.ctx, g)
+		return errors.Join(err, runHooks(stopCtx, "after stop", g.opts.hookTimeout, g.opts.afterStop))
+	}
+
+	// wait for servers ready before register service
+	if err = g.waitReady(ctx); err != nil {
+		return abort(err)
+	}
+
 	// register service
 	if g.opts.registry != nil {
-		c, cancel := context.WithTimeout(ctx, g.opts.registryTimeout)
-		defer cancel()
-		if err := g.opts.registry.Register(c, instance); err != nil {
-			return err
+		g.mdMu.Lock()
+		err = g.register(ctx)
+		if err == nil {
+			g.setRegistryState(RegistryRegistered)
 		}
+		g.mdMu.Unlock()
+		if err != nil {
+			return abort(err)
+		}
+		g.startHeartbeat(ctx)
 	}
 
 	for _, fn := range g.opts.afterStart {
 		if err = fn(ctx); err != nil {
-			return err
+			return abort(err)
 		}
 	}
 
@@ -135,36 +194,159 @@ func (g *Gaea) Run() error {
 		})
 	}
 
-	if err := eg.Wait(); err != nil && !errors.Is(err, context.Canceled) {
-		return err
+	err = eg.Wait()
+	if errors.Is(err, context.Canceled) {
+		err = nil
 	}
-	return nil
+
+	// a server failed or the parent context was cancelled without Stop,
+	// run the shutdown pipeline so that the instance does not stay registered
+	if g.RegistryState() != RegistryUnregistered {
+		err = errors.Join(err, g.Stop())
+	}
+
+	// servers are stopped, stop components in reverse order
+	if compErr := g.stopComponents(started); compErr != nil {
+		err = errors.Join(err, compErr)
+	}
+
+	// run the after stop hooks
+	stopCtx := NewContext(g.opts.ctx, g)
+	if hookErr := runHooks(stopCtx, "after stop", g.opts.hookTimeout, g.opts.afterStop); hookErr != nil {
+		err = errors.Join(err, hookErr)
+	}
+	return err
 }
 
 // Stop gracefully stops the application.
-func (g *Gaea) Stop() (err error) {
-	ctx := NewContext(g.ctx, g)
-	for _, fn := range g.opts.beforeStop {
-		err = fn(ctx)
+// The shutdown pipeline runs in order: BeforeStop hooks, health flip,
+// deregister, then the servers are stopped with the stop timeout and
+// AfterStop hooks run once Run observes that every server has returned.
+// Errors of every phase are aggregated, Stop is safe to call more than once.
+func (g *Gaea) Stop() error {
+	g.stopOnce.Do(func() {
+		g.stopErr = g.stop()
+	})
+	return g.stopErr
+}
+
+func (g *Gaea) stop() error {
+	var errs []error
+	// the app context may already be cancelled when Run stops the app
+	ctx := NewContext(context.WithoutCancel(g.ctx), g)
+	if err := runHooks(ctx, "before stop", g.opts.hookTimeout, g.opts.beforeStop); err != nil {
+		errs = append(errs, err)
+	}
+
+	// flip health to not serving, so that no new traffic is routed here
+	for _, srv := range g.opts.servers {
+		if d, ok := srv.(server.Drainer); ok {
+			d.Drain()
+		}
 	}
 
 	// deregister instance
+	if err := g.deregister(); err != nil {
+		errs = append(errs, err)
+	}
+
+	// cancel app, servers are stopped by Run
+	if g.cancel != nil {
+		g.cancel()
+	}
+	return errors.Join(errs...)
+}
+
+// deregister stops the heartbeat and removes the service instance from the
+// registry within the registry timeout.
+func (g *Gaea) deregister() error {
+	g.stopHeartbeat()
+	g.mdMu.Lock()
+	defer g.mdMu.Unlock()
 	g.mu.Lock()
 	instance := g.instance
 	g.mu.Unlock()
-	if g.opts.registry != nil && instance != nil {
-		ctx, cancel := context.WithTimeout(NewContext(g.ctx, g), g.opts.registryTimeout)
+	if g.opts.registry == nil || instance == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(NewContext(context.WithoutCancel(g.ctx), g), g.opts.registryTimeout)
+	defer cancel()
+	defer g.setRegistryState(RegistryUnregistered)
+	if err := g.opts.registry.Deregister(ctx, instance); err != nil {
+		return fmt.Errorf("gaea: deregister: %w", err)
+	}
+	return nil
+}
+
+// waitReady waits until every server is serving and all readiness probes
+// pass, within the ready timeout.
+func (g *Gaea) waitReady(ctx context.Context) error {
+	if g.opts.readyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.opts.readyTimeout)
 		defer cancel()
-		if err := g.opts.registry.Deregister(ctx, instance); err != nil {
-			return err
+	}
+	for _, srv := range g.opts.servers {
+		r, ok := srv.(server.Readier)
+		if !ok {
+			continue
+		}
+		select {
+		case <-r.Ready():
+		case <-ctx.Done():
+			return fmt.Errorf("gaea: wait for server ready: %w", ctx.Err())
 		}
 	}
+	for _, probe := range g.opts.readinessProbes {
+		if err := probe(ctx); err != nil {
+			return fmt.Errorf("gaea: readiness probe: %w", err)
+		}
+	}
+	return nil
+}
 
-	// cancel app
-	if g.cancel != nil {
-		g.cancel()
+// stopComponents stops cs within the stop timeout.
+func (g *Gaea) stopComponents(cs []Component) error {
+	if len(cs) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(NewContext(g.opts.ctx, g), g.opts.stopTimeout)
+	defer cancel()
+	return stopComponents(ctx, cs)
+}
+
+// runHooks runs fns in order within one phase. The whole phase shares a
+// single timeout so that a hung hook cannot block the process.
+func runHooks(ctx context.Context, phase string, timeout time.Duration, fns []func(context.Context) error) error {
+	if len(fns) == 0 {
+		return nil
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var errs []error
+		for _, fn := range fns {
+			if err := fn(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		done <- errors.Join(errs...)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("gaea: %s: %w", phase, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gaea: %s: %w", phase, ctx.Err())
 	}
-	return err
 }
 
 func (g *Gaea) buildInstance() (*registry.ServiceInstance, error) {
@@ -189,15 +371,13 @@ func (g *Gaea) buildInstance() (*registry.ServiceInstance, error) {
 	}, nil
 }
 
-type appKey struct{}
-
 // NewContext returns a new Context that carries value.
 func NewContext(ctx context.Context, s AppInfo) context.Context {
-	return context.WithValue(ctx, appKey{}, s)
+	return ic.NewAppContext(ctx, s)
 }
 
 // FromContext returns the Transport value stored in ctx, if any.
 func FromContext(ctx context.Context) (s AppInfo, ok bool) {
-	s, ok = ctx.Value(appKey{}).(AppInfo)
+	s, ok = ic.AppFromContext(ctx).(AppInfo)
 	return
 }
@@ -2,6 +2,7 @@ package gaea
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"reflect"
@@ -55,6 +56,255 @@ func TestApp(t *testing.T) {
 	}
 }
 
+func TestApp_StopPipeline(t *testing.T) {
+	var (
+		lk    sync.Mutex
+		order []string
+	)
+	record := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			lk.Lock()
+			defer lk.Unlock()
+			order = append(order, name)
+			return nil
+		}
+	}
+	app := New(
+		WithName("gaea"),
+		WithSignal(),
+		WithServer(grpc.NewServer()),
+		BeforeStop(record("before stop")),
+		AfterStop(record("after stop 1")),
+		AfterStop(record("after stop 2")),
+	)
+	time.AfterFunc(time.Second, func() {
+		_ = app.Stop()
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"before stop", "after stop 1", "after stop 2"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("hooks = %v, want %v", order, want)
+	}
+}
+
+func TestApp_StopAggregatesErrors(t *testing.T) {
+	err1 := errors.New("hook 1")
+	err2 := errors.New("hook 2")
+	app := New(
+		WithName("gaea"),
+		BeforeStop(func(context.Context) error { return err1 }),
+		BeforeStop(func(context.Context) error { return err2 }),
+	)
+	err := app.Stop()
+	if !errors.Is(err, err1) || !errors.Is(err, err2) {
+		t.Errorf("Stop() = %v, want both hook errors", err)
+	}
+	if again := app.Stop(); again != err {
+		t.Errorf("Stop() again = %v, want %v", again, err)
+	}
+}
+
+func TestApp_StopHookTimeout(t *testing.T) {
+	app := New(
+		WithName("gaea"),
+		WithHookTimeout(100*time.Millisecond),
+		BeforeStop(func(context.Context) error {
+			select {}
+		}),
+	)
+	start := time.Now()
+	err := app.Stop()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stop() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Stop() blocked on a hung hook")
+	}
+}
+
+type mockServer struct {
+	delay time.Duration
+	ready chan struct{}
+	stop  chan struct{}
+	// fail makes Start return the error sent on it
+	fail chan error
+}
+
+func newMockServer(delay time.Duration) *mockServer {
+	return &mockServer{delay: delay, ready: make(chan struct{}), stop: make(chan struct{}), fail: make(chan error, 1)}
+}
+
+func (s *mockServer) Start(context.Context) error {
+	time.Sleep(s.delay)
+	close(s.ready)
+	select {
+	case <-s.stop:
+		return nil
+	case err := <-s.fail:
+		return err
+	}
+}
+
+func (s *mockServer) Stop(context.Context) error {
+	close(s.stop)
+	return nil
+}
+
+func (s *mockServer) Endpoint() (*url.URL, error) {
+	return url.Parse("grpc://127.0.0.1:9000")
+}
+
+func (s *mockServer) Ready() <-chan struct{} { return s.ready }
+
+func TestApp_ReadyBeforeRegister(t *testing.T) {
+	srv := newMockServer(200 * time.Millisecond)
+	r := &mockRegistry{service: make(map[string]*registry.ServiceInstance)}
+	var probed bool
+	app := New(
+		WithName("gaea"),
+		WithSignal(),
+		WithServer(srv),
+		WithRegistry(r),
+		WithReadinessProbe(func(context.Context) error {
+			select {
+			case <-srv.Ready():
+			default:
+				t.Error("readiness probe run before server ready")
+			}
+			probed = true
+			return nil
+		}),
+		AfterStart(func(context.Context) error {
+			r.lk.Lock()
+			defer r.lk.Unlock()
+			if !probed || len(r.service) != 1 {
+				t.Error("service registered before ready")
+			}
+			return nil
+		}),
+	)
+	time.AfterFunc(time.Second, func() {
+		_ = app.Stop()
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApp_ReadyTimeout(t *testing.T) {
+	r := &mockRegistry{service: make(map[string]*registry.ServiceInstance)}
+	app := New(
+		WithName("gaea"),
+		WithSignal(),
+		WithServer(newMockServer(time.Second)),
+		WithRegistry(r),
+		WithReadyTimeout(100*time.Millisecond),
+	)
+	if err := app.Run(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(r.service) != 0 {
+		t.Errorf("expect service not registered, got %v", r.service)
+	}
+}
+
+func TestApp_ReadinessProbeError(t *testing.T) {
+	probeErr := errors.New("db ping failed")
+	r := &mockRegistry{service: make(map[string]*registry.ServiceInstance)}
+	app := New(
+		WithName("gaea"),
+		WithSignal(),
+		WithServer(newMockServer(0)),
+		WithRegistry(r),
+		WithReadinessProbe(func(context.Context) error { return probeErr }),
+	)
+	if err := app.Run(); !errors.Is(err, probeErr) {
+		t.Fatalf("Run() = %v, want %v", err, probeErr)
+	}
+	if len(r.service) != 0 {
+		t.Errorf("expect service not registered, got %v", r.service)
+	}
+}
+
+func TestApp_AfterStartErrorDeregisters(t *testing.T) {
+	hookErr := errors.New("warm up failed")
+	r := &mockRegistry{service: make(map[string]*registry.ServiceInstance)}
+	var stopped bool
+	app := New(
+		WithName("gaea"),
+		WithSignal(),
+		WithServer(newMockServer(0)),
+		WithRegistry(r),
+		AfterStart(func(context.Context) error { return hookErr }),
+		AfterStop(func(context.Context) error {
+			stopped = true
+			return nil
+		}),
+	)
+	if err := app.Run(); !errors.Is(err, hookErr) {
+		t.Fatalf("Run() = %v, want %v", err, hookErr)
+	}
+	if len(r.service) != 0 {
+		t.Errorf("expect service deregistered, got %v", r.service)
+	}
+	if app.RegistryState() != RegistryUnregistered {
+		t.Errorf("RegistryState() = %s, want %s", app.RegistryState(), RegistryUnregistered)
+	}
+	if !stopped {
+		t.Error("expect after stop hooks to run")
+	}
+}
+
+func TestApp_DeregisterWithoutStop(t *testing.T) {
+	errServer := errors.New("listener closed")
+	tests := []struct {
+		name string
+		end  func(srv *mockServer, cancel context.CancelFunc)
+		want error
+	}{
+		{"server error", func(srv *mockServer, _ context.CancelFunc) { srv.fail <- errServer }, errServer},
+		{"parent cancelled", func(_ *mockServer, cancel context.CancelFunc) { cancel() }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			srv := newMockServer(0)
+			r := &mockRegistry{service: make(map[string]*registry.ServiceInstance)}
+			var beforeStop bool
+			app := New(
+				WithName("gaea"),
+				WithContext(ctx),
+				WithSignal(),
+				WithServer(srv),
+				WithRegistry(r),
+				AfterStart(func(context.Context) error {
+					go tt.end(srv, cancel)
+					return nil
+				}),
+				BeforeStop(func(context.Context) error {
+					beforeStop = true
+					return nil
+				}),
+			)
+			if err := app.Run(); !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("Run() = %v, want %v", err, tt.want)
+			}
+			if len(r.service) != 0 {
+				t.Errorf("expect service deregistered, got %v", r.service)
+			}
+			if app.RegistryState() != RegistryUnregistered {
+				t.Errorf("RegistryState() = %s, want %s", app.RegistryState(), RegistryUnregistered)
+			}
+			if !beforeStop {
+				t.Error("expect before stop hooks to run")
+			}
+		})
+	}
+}
+
 func TestApp_ID(t *testing.T) {
 	v := "123"
 	o := New(WithID(v))
@@ -0,0 +1,135 @@
+package gaea
+
+import (
+	"context"
+	"time"
+
+	log "google.golang.org/grpc/grpclog"
+
+	"github.com/apus-run/gaea/internal/backoff"
+)
+
+// heartbeatJitter randomizes the heartbeat interval, so that instances
+// started together do not hit the registry at the same time.
+const heartbeatJitter = 0.1
+
+// RegistryState is the registration state of the service instance.
+type RegistryState int32
+
+const (
+	// RegistryUnregistered means the instance is not registered.
+	RegistryUnregistered RegistryState = iota
+	// RegistryRegistered means the last registration succeeded.
+	RegistryRegistered
+	// RegistryFailing means the last re-registration failed and is being retried.
+	RegistryFailing
+)
+
+func (s RegistryState) String() string {
+	switch s {
+	case RegistryUnregistered:
+		return "UNREGISTERED"
+	case RegistryRegistered:
+		return "REGISTERED"
+	case RegistryFailing:
+		return "FAILING"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// RegistryState returns the registration state of the service instance.
+func (g *Gaea) RegistryState() RegistryState {
+	return RegistryState(g.regState.Load())
+}
+
+func (g *Gaea) setRegistryState(s RegistryState) {
+	if old := RegistryState(g.regState.Swap(int32(s))); old != s {
+		log.Infof("[gaea] registry state changed: %s -> %s", old, s)
+	}
+}
+
+// register registers the current service instance within the registry timeout.
+func (g *Gaea) register(ctx context.Context) error {
+	g.mu.Lock()
+	instance := g.instance
+	g.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, g.opts.registryTimeout)
+	defer cancel()
+	return g.opts.registry.Register(ctx, instance)
+}
+
+// startHeartbeat keeps the registration alive until stopHeartbeat is called.
+func (g *Gaea) startHeartbeat(ctx context.Context) {
+	if g.opts.registryHeartbeat <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	g.mu.Lock()
+	g.heartbeatCancel = cancel
+	g.heartbeatDone = done
+	g.mu.Unlock()
+	go g.heartbeat(ctx, done)
+}
+
+// stopHeartbeat cancels the heartbeat loop and waits for it to exit, so
+// that the instance is not registered again after being deregistered.
+func (g *Gaea) stopHeartbeat() {
+	g.mu.Lock()
+	cancel, done := g.heartbeatCancel, g.heartbeatDone
+	g.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (g *Gaea) heartbeat(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	interval := g.opts.registryHeartbeat
+	bo := backoff.Exponential{
+		BaseDelay:  time.Second,
+		Multiplier: 1.6,
+		Jitter:     0.2,
+		MaxDelay:   interval,
+	}
+	if bo.BaseDelay > interval {
+		bo.BaseDelay = interval
+	}
+
+	timer := time.NewTimer(backoff.Jitter(interval, heartbeatJitter))
+	defer timer.Stop()
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		// serialize with the metadata updates, so that a beat started
+		// before an update does not register the stale metadata after it
+		g.mdMu.Lock()
+		err := g.register(ctx)
+		g.mdMu.Unlock()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Errorf("[gaea] failed to re-register service instance: %v", err)
+			g.setRegistryState(RegistryFailing)
+			if g.opts.registryErrorHandler != nil {
+				g.opts.registryErrorHandler(err)
+			}
+			timer.Reset(bo.Backoff(failures))
+			failures++
+			continue
+		}
+		failures = 0
+		g.setRegistryState(RegistryRegistered)
+		timer.Reset(backoff.Jitter(interval, heartbeatJitter))
+	}
+}
@@ -0,0 +1,106 @@
+package gaea
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apus-run/gaea/registry"
+)
+
+type flakyRegistry struct {
+	lk        sync.Mutex
+	registers int
+	failures  int
+	active    bool
+}
+
+func (r *flakyRegistry) Register(context.Context, *registry.ServiceInstance) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.registers++
+	// the first register succeeds, the following ones fail until failures run out
+	if r.registers > 1 && r.failures > 0 {
+		r.failures--
+		return errors.New("registry unavailable")
+	}
+	r.active = true
+	return nil
+}
+
+func (r *flakyRegistry) Deregister(context.Context, *registry.ServiceInstance) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.active = false
+	return nil
+}
+
+func (r *flakyRegistry) count() int {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return r.registers
+}
+
+func TestRegistryState_String(t *testing.T) {
+	tests := map[RegistryState]string{
+		RegistryUnregistered: "UNREGISTERED",
+		RegistryRegistered:   "REGISTERED",
+		RegistryFailing:      "FAILING",
+		RegistryState(100):   "UNKNOWN",
+	}
+	for s, want := range tests {
+		if got := s.String(); got != want {
+			t.Errorf("String() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestApp_RegistryHeartbeat(t *testing.T) {
+	r := &flakyRegistry{failures: 2}
+	var (
+		lk   sync.Mutex
+		errs []error
+	)
+	app := New(
+		WithName("gaea"),
+		WithSignal(),
+		WithRegistry(r),
+		WithRegistryHeartbeat(20*time.Millisecond),
+		WithRegistryErrorHandler(func(err error) {
+			lk.Lock()
+			defer lk.Unlock()
+			errs = append(errs, err)
+		}),
+	)
+	if got := app.RegistryState(); got != RegistryUnregistered {
+		t.Errorf("RegistryState() = %v, want %v", got, RegistryUnregistered)
+	}
+	time.AfterFunc(500*time.Millisecond, func() {
+		if got := app.RegistryState(); got != RegistryRegistered {
+			t.Errorf("RegistryState() = %v, want %v", got, RegistryRegistered)
+		}
+		_ = app.Stop()
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := r.count(); n < 4 {
+		t.Errorf("expect at least 4 registrations, got %d", n)
+	}
+	if len(errs) != 2 {
+		t.Errorf("expect 2 registry errors, got %v", errs)
+	}
+	if got := app.RegistryState(); got != RegistryUnregistered {
+		t.Errorf("RegistryState() = %v, want %v", got, RegistryUnregistered)
+	}
+
+	// no more registration once stopped
+	n := r.count()
+	time.Sleep(100 * time.Millisecond)
+	if r.count() != n || r.active {
+		t.Errorf("expect heartbeat stopped after Stop")
+	}
+}
@@ -0,0 +1,56 @@
+package backoff
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Exponential implements exponential backoff with randomized jitter.
+type Exponential struct {
+	// BaseDelay is the amount of time to backoff after the first failure.
+	BaseDelay time.Duration
+	// Multiplier is the factor with which to multiply backoffs after a
+	// failed retry.
+	Multiplier float64
+	// Jitter is the factor with which backoffs are randomized.
+	Jitter float64
+	// MaxDelay is the upper bound of backoff delay.
+	MaxDelay time.Duration
+}
+
+// DefaultExponential is an exponential backoff from 1s up to 120s.
+var DefaultExponential = Exponential{
+	BaseDelay:  1 * time.Second,
+	Multiplier: 1.6,
+	Jitter:     0.2,
+	MaxDelay:   120 * time.Second,
+}
+
+// Backoff returns the amount of time to wait before the next retry given
+// the number of retries.
+func (e Exponential) Backoff(retries int) time.Duration {
+	if retries == 0 {
+		return e.BaseDelay
+	}
+	backoff, max := float64(e.BaseDelay), float64(e.MaxDelay)
+	for backoff < max && retries > 0 {
+		backoff *= e.Multiplier
+		retries--
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return Jitter(time.Duration(backoff), e.Jitter)
+}
+
+// Jitter randomizes d by ±factor.
+func Jitter(d time.Duration, factor float64) time.Duration {
+	if factor <= 0 {
+		return d
+	}
+	j := float64(d) * (1 + factor*(rand.Float64()*2-1))
+	if j < 0 {
+		return 0
+	}
+	return time.Duration(j)
+}
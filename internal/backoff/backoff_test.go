@@ -0,0 +1,42 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExponential(t *testing.T) {
+	b := Exponential{
+		BaseDelay:  time.Second,
+		Multiplier: 2,
+		MaxDelay:   10 * time.Second,
+	}
+	tests := []struct {
+		retries int
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := b.Backoff(tt.retries); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.retries, got, tt.want)
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	d := time.Second
+	for i := 0; i < 100; i++ {
+		got := Jitter(d, 0.2)
+		if got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("Jitter(%v, 0.2) = %v, out of range", d, got)
+		}
+	}
+	if got := Jitter(d, 0); got != d {
+		t.Errorf("Jitter(%v, 0) = %v, want %v", d, got, d)
+	}
+}
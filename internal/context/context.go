@@ -113,3 +113,17 @@ func (mc *mergeCtx) Value(key interface{}) interface{} {
 	}
 	return mc.parent2.Value(key)
 }
+
+type appKey struct{}
+
+// NewAppContext returns a new Context that carries the application info.
+// The application info is stored here rather than in the gaea package, so
+// that the transports can read it without importing gaea.
+func NewAppContext(ctx context.Context, app any) context.Context {
+	return context.WithValue(ctx, appKey{}, app)
+}
+
+// AppFromContext returns the application info stored in ctx, if any.
+func AppFromContext(ctx context.Context) any {
+	return ctx.Value(appKey{})
+}
@@ -0,0 +1,51 @@
+// Package selector matches gRPC full method names against selectors, such
+// as /helloworld.Greeter/SayHello for a method or /helloworld.Greeter/* for
+// every method of a service.
+package selector
+
+import (
+	"sort"
+	"strings"
+)
+
+// Selector maps selectors to values.
+type Selector[T any] struct {
+	prefix []string
+	values map[string]T
+}
+
+// Add adds the value of the selector, a selector ending with "*" matches
+// every operation with the prefix.
+func (s *Selector[T]) Add(selector string, v T) {
+	if s.values == nil {
+		s.values = make(map[string]T)
+	}
+	if strings.HasSuffix(selector, "*") {
+		selector = strings.TrimSuffix(selector, "*")
+		s.prefix = append(s.prefix, selector)
+		// the longest prefix wins
+		sort.Slice(s.prefix, func(i, j int) bool {
+			return s.prefix[i] > s.prefix[j]
+		})
+	}
+	s.values[selector] = v
+}
+
+// Match returns the value of the exact selector of operation, or else the
+// value of its longest prefix selector.
+func (s *Selector[T]) Match(operation string) (v T, ok bool) {
+	if v, ok = s.values[operation]; ok {
+		return v, true
+	}
+	for _, prefix := range s.prefix {
+		if strings.HasPrefix(operation, prefix) {
+			return s.values[prefix], true
+		}
+	}
+	return v, false
+}
+
+// Len returns the number of selectors.
+func (s *Selector[T]) Len() int {
+	return len(s.values)
+}
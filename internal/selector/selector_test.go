@@ -0,0 +1,32 @@
+package selector
+
+import (
+	"testing"
+)
+
+func TestSelector(t *testing.T) {
+	var s Selector[int]
+	if _, ok := s.Match("/helloworld.Greeter/SayHello"); ok {
+		t.Fatal("expect no match")
+	}
+	s.Add("/*", 1)
+	s.Add("/helloworld.Greeter/*", 2)
+	s.Add("/helloworld.Greeter/SayHello", 3)
+
+	tests := []struct {
+		operation string
+		want      int
+	}{
+		{"/helloworld.Greeter/SayHello", 3},
+		{"/helloworld.Greeter/SayBye", 2},
+		{"/echo.Echo/Echo", 1},
+	}
+	for _, tt := range tests {
+		if got, ok := s.Match(tt.operation); !ok || got != tt.want {
+			t.Errorf("Match(%s) = %d, want %d", tt.operation, got, tt.want)
+		}
+	}
+	if s.Len() != 3 {
+		t.Errorf("expect 3 selectors, got %d", s.Len())
+	}
+}
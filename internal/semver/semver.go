@@ -0,0 +1,239 @@
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version, build metadata is ignored.
+type Version struct {
+	Major, Minor, Patch uint64
+	Pre                 string
+}
+
+// Parse parses a version such as "v1.2.3", "1.2" or "1.2.3-rc.1".
+// Missing minor and patch numbers are zero.
+func Parse(v string) (Version, error) {
+	var ver Version
+	s := strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		ver.Pre = s[i+1:]
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 || s == "" {
+		return ver, fmt.Errorf("semver: invalid version %q", v)
+	}
+	nums := []*uint64{&ver.Major, &ver.Minor, &ver.Patch}
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return ver, fmt.Errorf("semver: invalid version %q", v)
+		}
+		*nums[i] = n
+	}
+	return ver, nil
+}
+
+// Compare returns -1, 0 or +1 as v is less than, equal to or greater than o.
+func (v Version) Compare(o Version) int {
+	if c := compareInt(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	return comparePre(v.Pre, o.Pre)
+}
+
+func compareInt(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// comparePre compares pre-release versions, a version without pre-release
+// is greater than any pre-release of it.
+func comparePre(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.ParseUint(as[i], 10, 64)
+		bn, berr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aerr == nil && berr == nil:
+			if c := compareInt(an, bn); c != 0 {
+				return c
+			}
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInt(uint64(len(as)), uint64(len(bs)))
+}
+
+// Constraint is a version range such as ">=1.2.0 <2.0.0", "^1.2" or
+// "~1.2.3 || 2.x". Comparisons separated by spaces or commas must all
+// match, alternatives are separated by "||".
+type Constraint struct {
+	alternatives [][]comparison
+}
+
+type comparison struct {
+	op  string
+	ver Version
+}
+
+// ParseConstraint parses a version constraint.
+func ParseConstraint(c string) (*Constraint, error) {
+	constraint := &Constraint{}
+	for _, alt := range strings.Split(c, "||") {
+		var cmps []comparison
+		for _, f := range strings.FieldsFunc(alt, func(r rune) bool { return r == ' ' || r == ',' }) {
+			cs, err := parseComparison(f)
+			if err != nil {
+				return nil, err
+			}
+			cmps = append(cmps, cs...)
+		}
+		if len(cmps) == 0 {
+			return nil, fmt.Errorf("semver: invalid constraint %q", c)
+		}
+		constraint.alternatives = append(constraint.alternatives, cmps)
+	}
+	return constraint, nil
+}
+
+func parseComparison(s string) ([]comparison, error) {
+	op := ""
+	for _, o := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(s, o) {
+			op, s = o, strings.TrimPrefix(s, o)
+			break
+		}
+	}
+
+	// wildcards: 1.x, 1.2.*
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	wildcard := -1
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			wildcard = i
+			parts = parts[:i]
+			break
+		}
+	}
+	if wildcard >= 0 {
+		if op != "" && op != "=" {
+			return nil, fmt.Errorf("semver: invalid constraint %q", s)
+		}
+		if len(parts) == 0 {
+			return []comparison{{op: ">=", ver: Version{}}}, nil
+		}
+		lower, err := Parse(strings.Join(parts, "."))
+		if err != nil {
+			return nil, err
+		}
+		return []comparison{{op: ">=", ver: lower}, {op: "<", ver: bump(lower, len(parts)-1)}}, nil
+	}
+
+	ver, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "^":
+		// compatible with the left-most non-zero number
+		upper := Version{Major: ver.Major + 1}
+		if ver.Major == 0 {
+			upper = Version{Minor: ver.Minor + 1}
+			if ver.Minor == 0 && len(parts) == 3 {
+				upper = Version{Patch: ver.Patch + 1}
+			}
+		}
+		return []comparison{{op: ">=", ver: ver}, {op: "<", ver: upper}}, nil
+	case "~":
+		// patch updates, or minor updates if only the major is given
+		field := 1
+		if len(parts) == 1 {
+			field = 0
+		}
+		return []comparison{{op: ">=", ver: ver}, {op: "<", ver: bump(ver, field)}}, nil
+	case "":
+		op = "="
+	}
+	return []comparison{{op: op, ver: ver}}, nil
+}
+
+// bump returns the smallest version greater than v in the field, 0 is major.
+func bump(v Version, field int) Version {
+	switch field {
+	case 0:
+		return Version{Major: v.Major + 1}
+	case 1:
+		return Version{Major: v.Major, Minor: v.Minor + 1}
+	default:
+		return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+	}
+}
+
+// Check reports whether the version satisfies the constraint.
+func (c *Constraint) Check(v Version) bool {
+	for _, cmps := range c.alternatives {
+		ok := true
+		for _, cmp := range cmps {
+			if !cmp.check(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c comparison) check(v Version) bool {
+	r := v.Compare(c.ver)
+	switch c.op {
+	case "=":
+		return r == 0
+	case "!=":
+		return r != 0
+	case ">":
+		return r > 0
+	case ">=":
+		return r >= 0
+	case "<":
+		return r < 0
+	case "<=":
+		return r <= 0
+	}
+	return false
+}
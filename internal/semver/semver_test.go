@@ -0,0 +1,96 @@
+package semver
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Version
+		err  bool
+	}{
+		{in: "v1.2.3", want: Version{1, 2, 3, ""}},
+		{in: "1.2", want: Version{1, 2, 0, ""}},
+		{in: "1.2.3-rc.1+build.5", want: Version{1, 2, 3, "rc.1"}},
+		{in: "", err: true},
+		{in: "1.2.3.4", err: true},
+		{in: "v1.a", err: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("Parse(%q) error = %v", tt.in, err)
+			continue
+		}
+		if !tt.err && got != tt.want {
+			t.Errorf("Parse(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.10.0", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-rc.2", "1.0.0-rc.10", -1},
+		{"1.0.0-beta", "1.0.0-alpha", 1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+	}
+	for _, tt := range tests {
+		a, _ := Parse(tt.a)
+		b, _ := Parse(tt.b)
+		if got := a.Compare(b); got != tt.want {
+			t.Errorf("Compare(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"1.2.3", "v1.2.3", true},
+		{"1.2.3", "v1.2.4", false},
+		{">=1.2.0 <2.0.0", "1.5.0", true},
+		{">=1.2.0, <2.0.0", "2.0.0", false},
+		{"!=1.2.0", "1.2.0", false},
+		{"^1.2", "1.9.9", true},
+		{"^1.2", "2.0.0", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.4", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{"1.x", "1.4.2", true},
+		{"1.2.*", "1.3.0", false},
+		{"*", "0.0.1", true},
+		{"<1.0.0 || >=2.0.0", "2.1.0", true},
+		{"<1.0.0 || >=2.0.0", "1.1.0", false},
+	}
+	for _, tt := range tests {
+		c, err := ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q) error = %v", tt.constraint, err)
+			continue
+		}
+		v, _ := Parse(tt.version)
+		if got := c.Check(v); got != tt.want {
+			t.Errorf("%q.Check(%s) = %v, want %v", tt.constraint, tt.version, got, tt.want)
+		}
+	}
+
+	for _, c := range []string{"", ">=a", ">1.x", "1.2 ||"} {
+		if _, err := ParseConstraint(c); err == nil {
+			t.Errorf("ParseConstraint(%q) expect error", c)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package gaea
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/apus-run/gaea/registry"
+)
+
+// SetMetadata sets the key value pairs of the service metadata at runtime,
+// such as weight, zone or state=draining. If the instance is registered the
+// change is pushed to the registry, so that discovery clients observe it
+// without a restart.
+func (g *Gaea) SetMetadata(ctx context.Context, kv map[string]string) error {
+	return g.updateMetadata(ctx, func(md map[string]string) {
+		for k, v := range kv {
+			md[k] = v
+		}
+	})
+}
+
+// DeleteMetadata deletes the keys of the service metadata at runtime and
+// pushes the change to the registry like SetMetadata.
+func (g *Gaea) DeleteMetadata(ctx context.Context, keys ...string) error {
+	return g.updateMetadata(ctx, func(md map[string]string) {
+		for _, k := range keys {
+			delete(md, k)
+		}
+	})
+}
+
+// updateMetadata applies fn to a copy of the metadata, the maps already
+// handed out by Metadata or held by the registry are never mutated.
+func (g *Gaea) updateMetadata(ctx context.Context, fn func(md map[string]string)) error {
+	// serialize updates, so that they reach the registry in order
+	g.mdMu.Lock()
+	defer g.mdMu.Unlock()
+
+	g.mu.Lock()
+	md := make(map[string]string, len(g.opts.metadata))
+	for k, v := range g.opts.metadata {
+		md[k] = v
+	}
+	fn(md)
+	g.opts.metadata = md
+	var instance *registry.ServiceInstance
+	if g.instance != nil {
+		copied := *g.instance
+		copied.Metadata = md
+		g.instance = &copied
+		instance = g.instance
+	}
+	g.mu.Unlock()
+
+	if g.opts.registry == nil || instance == nil || g.RegistryState() == RegistryUnregistered {
+		return nil
+	}
+	if err := g.register(ctx); err != nil {
+		return fmt.Errorf("gaea: update metadata: %w", err)
+	}
+	return nil
+}
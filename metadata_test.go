@@ -0,0 +1,136 @@
+package gaea
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apus-run/gaea/registry"
+)
+
+func TestApp_SetMetadata(t *testing.T) {
+	app := New(WithMetadata(map[string]string{"zone": "a"}))
+	if err := app.SetMetadata(context.Background(), map[string]string{"weight": "10"}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"zone": "a", "weight": "10"}
+	if !reflect.DeepEqual(app.Metadata(), want) {
+		t.Errorf("Metadata() = %v, want %v", app.Metadata(), want)
+	}
+	if err := app.DeleteMetadata(context.Background(), "zone"); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]string{"weight": "10"}
+	if !reflect.DeepEqual(app.Metadata(), want) {
+		t.Errorf("Metadata() = %v, want %v", app.Metadata(), want)
+	}
+}
+
+func TestApp_SetMetadataPropagation(t *testing.T) {
+	r := &mockRegistry{service: make(map[string]*registry.ServiceInstance)}
+	md := map[string]string{"zone": "a"}
+	var app *Gaea
+	app = New(
+		WithID("1"),
+		WithName("gaea"),
+		WithSignal(),
+		WithMetadata(md),
+		WithRegistry(r),
+		AfterStart(func(ctx context.Context) error {
+			return app.SetMetadata(ctx, map[string]string{"state": "draining"})
+		}),
+	)
+	time.AfterFunc(200*time.Millisecond, func() {
+		r.lk.Lock()
+		got := r.service["1"].Metadata
+		r.lk.Unlock()
+		want := map[string]string{"zone": "a", "state": "draining"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("registered metadata = %v, want %v", got, want)
+		}
+		_ = app.Stop()
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	// the original metadata is never mutated
+	if !reflect.DeepEqual(md, map[string]string{"zone": "a"}) {
+		t.Errorf("WithMetadata map mutated: %v", md)
+	}
+	// no push once deregistered
+	if err := app.SetMetadata(context.Background(), map[string]string{"a": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.service) != 0 {
+		t.Errorf("expect instance not registered again, got %v", r.service)
+	}
+}
+
+// slowRegistry records the metadata of every completed registration, the
+// registrations without the state metadata are slower.
+type slowRegistry struct {
+	lk  sync.Mutex
+	mds []map[string]string
+}
+
+func (r *slowRegistry) Register(_ context.Context, svc *registry.ServiceInstance) error {
+	if _, ok := svc.Metadata["state"]; ok {
+		time.Sleep(10 * time.Millisecond)
+	} else {
+		time.Sleep(40 * time.Millisecond)
+	}
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.mds = append(r.mds, svc.Metadata)
+	return nil
+}
+
+func (r *slowRegistry) Deregister(context.Context, *registry.ServiceInstance) error {
+	return nil
+}
+
+func TestApp_SetMetadataDuringHeartbeat(t *testing.T) {
+	r := &slowRegistry{}
+	var app *Gaea
+	app = New(
+		WithName("gaea"),
+		WithSignal(),
+		WithServer(newMockServer(0)),
+		WithRegistry(r),
+		WithRegistryHeartbeat(time.Millisecond),
+		AfterStart(func(ctx context.Context) error {
+			go func() {
+				for i := 0; i < 5; i++ {
+					// read the endpoints concurrently with the updates
+					_ = app.Endpoint()
+					time.Sleep(10 * time.Millisecond)
+				}
+			}()
+			time.Sleep(30 * time.Millisecond)
+			return app.SetMetadata(ctx, map[string]string{"state": "draining"})
+		}),
+	)
+	time.AfterFunc(200*time.Millisecond, func() {
+		_ = app.Stop()
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// once pushed, the new metadata is never overwritten by a stale beat
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	pushed := false
+	for _, md := range r.mds {
+		if md["state"] == "draining" {
+			pushed = true
+		} else if pushed {
+			t.Fatalf("stale metadata registered after the update: %v", r.mds)
+		}
+	}
+	if !pushed {
+		t.Fatal("expect the metadata pushed")
+	}
+}
@@ -0,0 +1,219 @@
+package circuitbreaker
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// StateClosed lets all the requests through.
+	StateClosed State = iota
+	// StateOpen rejects the requests.
+	StateOpen
+	// StateHalfOpen lets a few requests through to probe the backend.
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Breaker is a circuit breaker.
+type Breaker interface {
+	// Allow reports whether the request may go through, every allowed
+	// request must be followed by MarkSuccess, MarkFailed or Release with
+	// the returned generation. The outcomes of the requests allowed before
+	// the breaker changed its state are ignored.
+	Allow() (generation uint64, ok bool)
+	MarkSuccess(generation uint64)
+	MarkFailed(generation uint64)
+	// Release gives an allowed request back without an outcome, such as a
+	// request canceled by the caller.
+	Release(generation uint64)
+	// State returns the current state of the breaker.
+	State() State
+}
+
+// BreakerOption is three-state breaker option.
+type BreakerOption func(*breaker)
+
+// WithWindow with the rolling window of the failure ratio, default is 10s.
+func WithWindow(d time.Duration) BreakerOption {
+	return func(b *breaker) {
+		b.window = newWindow(d, 10)
+	}
+}
+
+// WithFailureRatio with the failure ratio which opens the breaker, default is 0.5.
+func WithFailureRatio(ratio float64) BreakerOption {
+	return func(b *breaker) {
+		b.ratio = ratio
+	}
+}
+
+// WithMinRequests with the minimum requests in the window before the
+// breaker may open, default is 20.
+func WithMinRequests(n int64) BreakerOption {
+	return func(b *breaker) {
+		b.minRequests = n
+	}
+}
+
+// WithOpenTimeout with the duration the breaker stays open before probing
+// the backend, default is 5s.
+func WithOpenTimeout(d time.Duration) BreakerOption {
+	return func(b *breaker) {
+		b.openTimeout = d
+	}
+}
+
+// WithProbes with the successful requests needed in half-open state to
+// close the breaker, default is 5.
+func WithProbes(n int) BreakerOption {
+	return func(b *breaker) {
+		b.probes = n
+	}
+}
+
+// WithStateChange with the callback invoked on every state transition, it
+// must not call the breaker.
+func WithStateChange(fn func(from, to State)) BreakerOption {
+	return func(b *breaker) {
+		b.onStateChange = fn
+	}
+}
+
+// breaker is a three-state circuit breaker: it opens when the failure
+// ratio in the window exceeds the threshold, lets a few probes through
+// once the open timeout expires, and closes when all the probes succeed.
+type breaker struct {
+	mu            sync.Mutex
+	state         State
+	generation    uint64
+	openedAt      time.Time
+	inflight      int
+	succeeded     int
+	window        *window
+	ratio         float64
+	minRequests   int64
+	openTimeout   time.Duration
+	probes        int
+	onStateChange func(from, to State)
+	now           func() time.Time
+}
+
+// NewBreaker creates a three-state circuit breaker.
+func NewBreaker(opts ...BreakerOption) Breaker {
+	b := &breaker{
+		window:      newWindow(10*time.Second, 10),
+		ratio:       0.5,
+		minRequests: 20,
+		openTimeout: 5 * time.Second,
+		probes:      5,
+		now:         time.Now,
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	return b
+}
+
+func (b *breaker) Allow() (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case StateOpen:
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return b.generation, false
+		}
+		b.setState(StateHalfOpen)
+		fallthrough
+	case StateHalfOpen:
+		if b.inflight+b.succeeded >= b.probes {
+			return b.generation, false
+		}
+		b.inflight++
+	}
+	return b.generation, true
+}
+
+func (b *breaker) MarkSuccess(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+	switch b.state {
+	case StateClosed:
+		b.window.add(false)
+	case StateHalfOpen:
+		b.inflight--
+		b.succeeded++
+		if b.succeeded >= b.probes {
+			b.setState(StateClosed)
+		}
+	}
+}
+
+func (b *breaker) MarkFailed(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+	switch b.state {
+	case StateClosed:
+		b.window.add(true)
+		requests, failures := b.window.sum()
+		if requests >= b.minRequests && float64(failures)/float64(requests) >= b.ratio {
+			b.setState(StateOpen)
+		}
+	case StateHalfOpen:
+		b.setState(StateOpen)
+	}
+}
+
+func (b *breaker) Release(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation == b.generation && b.state == StateHalfOpen {
+		b.inflight--
+	}
+}
+
+func (b *breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.openTimeout {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// setState must be called with b.mu held.
+func (b *breaker) setState(s State) {
+	from := b.state
+	b.state = s
+	b.generation++
+	b.inflight, b.succeeded = 0, 0
+	switch s {
+	case StateOpen:
+		b.openedAt = b.now()
+	case StateClosed:
+		b.window.reset()
+	}
+	if b.onStateChange != nil {
+		b.onStateChange(from, s)
+	}
+}
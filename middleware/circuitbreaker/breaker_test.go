@@ -0,0 +1,207 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newTestBreaker(c *clock, opts ...BreakerOption) *breaker {
+	b := NewBreaker(opts...).(*breaker)
+	b.now = c.now
+	b.window.now = c.now
+	return b
+}
+
+// call runs a request through the breaker, it reports whether the request
+// was allowed.
+func call(b Breaker, failed bool) bool {
+	generation, ok := b.Allow()
+	if !ok {
+		return false
+	}
+	if failed {
+		b.MarkFailed(generation)
+	} else {
+		b.MarkSuccess(generation)
+	}
+	return true
+}
+
+func TestBreaker(t *testing.T) {
+	c := &clock{t: time.Unix(1000, 0)}
+	var transitions []string
+	b := newTestBreaker(c,
+		WithMinRequests(10),
+		WithProbes(2),
+		WithStateChange(func(from, to State) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		}),
+	)
+
+	// below the minimum requests
+	for i := 0; i < 9; i++ {
+		if !call(b, true) {
+			t.Fatal("expect allowed")
+		}
+	}
+	if b.State() != StateClosed {
+		t.Fatalf("expect closed, got %v", b.State())
+	}
+	call(b, true)
+	if b.State() != StateOpen {
+		t.Fatalf("expect open, got %v", b.State())
+	}
+	if _, ok := b.Allow(); ok {
+		t.Fatal("expect rejected")
+	}
+
+	// probe after the open timeout, a failed probe opens the breaker again
+	c.t = c.t.Add(5 * time.Second)
+	if b.State() != StateHalfOpen {
+		t.Fatalf("expect half-open, got %v", b.State())
+	}
+	if !call(b, true) {
+		t.Fatal("expect probe allowed")
+	}
+	if b.State() != StateOpen {
+		t.Fatalf("expect open, got %v", b.State())
+	}
+
+	// successful probes close the breaker
+	c.t = c.t.Add(5 * time.Second)
+	g1, ok1 := b.Allow()
+	g2, ok2 := b.Allow()
+	if !ok1 || !ok2 {
+		t.Fatal("expect probes allowed")
+	}
+	if _, ok := b.Allow(); ok {
+		t.Fatal("expect at most 2 probes")
+	}
+	b.MarkSuccess(g1)
+	b.MarkSuccess(g2)
+	if b.State() != StateClosed {
+		t.Fatalf("expect closed, got %v", b.State())
+	}
+	// the failures before opening are forgotten
+	call(b, true)
+	if b.State() != StateClosed {
+		t.Fatalf("expect closed, got %v", b.State())
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("expect %v, got %v", want, transitions)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Fatalf("expect %v, got %v", want, transitions)
+		}
+	}
+}
+
+func TestBreaker_StaleOutcomes(t *testing.T) {
+	c := &clock{t: time.Unix(1000, 0)}
+	b := newTestBreaker(c, WithMinRequests(1), WithProbes(2))
+
+	// requests allowed while closed, still in flight when the breaker opens
+	stale1, _ := b.Allow()
+	stale2, _ := b.Allow()
+	call(b, true)
+	if b.State() != StateOpen {
+		t.Fatalf("expect open, got %v", b.State())
+	}
+
+	c.t = c.t.Add(5 * time.Second)
+	probe, ok := b.Allow()
+	if !ok {
+		t.Fatal("expect probe allowed")
+	}
+	// the stale outcomes neither count as probes nor close the breaker
+	b.MarkSuccess(stale1)
+	b.MarkSuccess(stale2)
+	b.Release(stale1)
+	if b.State() != StateHalfOpen {
+		t.Fatalf("expect half-open, got %v", b.State())
+	}
+	if b.inflight != 1 {
+		t.Fatalf("expect 1 probe in flight, got %d", b.inflight)
+	}
+	b.MarkFailed(stale2)
+	if b.State() != StateHalfOpen {
+		t.Fatalf("expect half-open, got %v", b.State())
+	}
+	b.MarkSuccess(probe)
+	if !call(b, false) || b.State() != StateClosed {
+		t.Fatalf("expect closed, got %v", b.State())
+	}
+}
+
+func TestBreaker_Window(t *testing.T) {
+	c := &clock{t: time.Unix(1000, 0)}
+	b := newTestBreaker(c, WithMinRequests(10))
+	for i := 0; i < 9; i++ {
+		call(b, true)
+	}
+	// the failures slide out of the window
+	c.t = c.t.Add(11 * time.Second)
+	call(b, true)
+	if b.State() != StateClosed {
+		t.Fatalf("expect closed, got %v", b.State())
+	}
+	for i := 0; i < 20; i++ {
+		call(b, false)
+	}
+	// 19 failures out of 39 requests
+	for i := 0; i < 18; i++ {
+		call(b, true)
+	}
+	if b.State() != StateClosed {
+		t.Fatalf("expect closed below the failure ratio, got %v", b.State())
+	}
+	call(b, true)
+	if b.State() != StateOpen {
+		t.Fatalf("expect open at the failure ratio, got %v", b.State())
+	}
+}
+
+func TestSREBreaker(t *testing.T) {
+	c := &clock{t: time.Unix(1000, 0)}
+	b := NewSREBreaker(WithSREMinRequests(10)).(*sreBreaker)
+	b.window.now = c.now
+
+	for i := 0; i < 100; i++ {
+		b.MarkSuccess(0)
+	}
+	if b.State() != StateClosed {
+		t.Fatalf("expect closed, got %v", b.State())
+	}
+	for i := 0; i < 300; i++ {
+		b.MarkFailed(0)
+	}
+	if b.State() != StateOpen {
+		t.Fatalf("expect throttling, got %v", b.State())
+	}
+	rejected := 0
+	for i := 0; i < 1000; i++ {
+		if _, ok := b.Allow(); !ok {
+			rejected++
+		}
+	}
+	if rejected == 0 || rejected == 1000 {
+		t.Errorf("expect part of the requests rejected, got %d", rejected)
+	}
+
+	// recovered once the failures slide out of the window
+	c.t = c.t.Add(11 * time.Second)
+	if _, ok := b.Allow(); !ok || b.State() != StateClosed {
+		t.Errorf("expect closed, got %v", b.State())
+	}
+}
@@ -0,0 +1,184 @@
+// Package circuitbreaker provides a client middleware which rejects the
+// calls to a failing backend fast instead of piling up requests on it.
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/apus-run/gaea/middleware"
+)
+
+// ErrNotAllowed is returned when the circuit breaker rejects a call.
+var ErrNotAllowed = status.Error(codes.Unavailable, "circuit breaker is open")
+
+// Option is circuit breaker middleware option.
+type Option func(*options)
+
+type options struct {
+	group      *Group
+	perTarget  bool
+	perBackend bool
+	isFailure  func(error) bool
+}
+
+// WithGroup with the group of breakers, default is a group of three-state
+// breakers. Keep a reference to the group to export the states as metrics.
+func WithGroup(g *Group) Option {
+	return func(o *options) {
+		o.group = g
+	}
+}
+
+// WithPerTarget keys the breakers by target and method instead of method
+// only, so that a failing dependency does not open the breaker of another
+// dependency serving the same method.
+// The target is the dial target, such as discovery:///helloworld, see
+// WithPerBackend to tell apart the instances behind it.
+func WithPerTarget() Option {
+	return func(o *options) {
+		o.perTarget = true
+	}
+}
+
+// WithPerBackend keys the breakers by backend address and method, the
+// breaker is checked when the balancer of the gRPC client picks the backend
+// and a backend whose breaker is open is skipped for another one.
+// The calls are rejected with ErrNotAllowed only when the balancer keeps
+// picking backends whose breaker is open.
+func WithPerBackend() Option {
+	return func(o *options) {
+		o.perBackend = true
+	}
+}
+
+// WithFailure with the predicate reporting whether an error is a failure
+// of the backend, default is IsFailure.
+func WithFailure(fn func(error) bool) Option {
+	return func(o *options) {
+		o.isFailure = fn
+	}
+}
+
+// IsFailure reports whether err indicates an unhealthy backend, the
+// errors caused by the request itself, such as InvalidArgument or
+// NotFound, do not count.
+func IsFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal,
+		codes.ResourceExhausted, codes.Unknown, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+func isCanceled(err error) bool {
+	return status.Code(err) == codes.Canceled || errors.Is(err, context.Canceled)
+}
+
+// Client is a client middleware which guards every method with its own
+// circuit breaker and rejects the calls with ErrNotAllowed while the
+// breaker is open.
+func Client(opts ...Option) middleware.Middleware {
+	o := options{
+		isFailure: IsFailure,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.group == nil {
+		o.group = NewGroup(func() Breaker {
+			return NewBreaker()
+		})
+	}
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			key, _ := middleware.OperationFromContext(ctx)
+			if o.perTarget {
+				target, _ := middleware.TargetFromContext(ctx)
+				key = target + key
+			}
+			if o.perBackend {
+				ctx = middleware.NewPickContext(ctx, func(addr string) (func(error), error) {
+					b := o.group.Get(addr + key)
+					generation, ok := b.Allow()
+					if !ok {
+						return nil, ErrNotAllowed
+					}
+					return func(err error) {
+						o.mark(b, generation, err)
+					}, nil
+				})
+				return handler(ctx, req)
+			}
+			b := o.group.Get(key)
+			generation, ok := b.Allow()
+			if !ok {
+				return nil, ErrNotAllowed
+			}
+			reply, err := handler(ctx, req)
+			o.mark(b, generation, err)
+			return reply, err
+		}
+	}
+}
+
+// mark reports the outcome of a call allowed by b.
+func (o *options) mark(b Breaker, generation uint64, err error) {
+	switch {
+	case isCanceled(err) || errors.Is(err, middleware.ErrNotSent):
+		// the caller gave up, it says nothing about the backend
+		b.Release(generation)
+	case err != nil && o.isFailure(err):
+		b.MarkFailed(generation)
+	default:
+		b.MarkSuccess(generation)
+	}
+}
+
+// Group is a set of breakers created on demand by key.
+type Group struct {
+	mu       sync.RWMutex
+	new      func() Breaker
+	breakers map[string]Breaker
+}
+
+// NewGroup creates a group whose breakers are created by fn.
+func NewGroup(fn func() Breaker) *Group {
+	return &Group{
+		new:      fn,
+		breakers: make(map[string]Breaker),
+	}
+}
+
+// Get returns the breaker of key, it is created if absent.
+func (g *Group) Get(key string) Breaker {
+	g.mu.RLock()
+	b, ok := g.breakers[key]
+	g.mu.RUnlock()
+	if ok {
+		return b
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if b, ok = g.breakers[key]; !ok {
+		b = g.new()
+		g.breakers[key] = b
+	}
+	return b
+}
+
+// States returns the state of every breaker by key.
+func (g *Group) States() map[string]State {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	states := make(map[string]State, len(g.breakers))
+	for key, b := range g.breakers {
+		states[key] = b.State()
+	}
+	return states
+}
@@ -0,0 +1,170 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/apus-run/gaea/middleware"
+)
+
+func TestIsFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{status.Error(codes.Unavailable, ""), true},
+		{status.Error(codes.DeadlineExceeded, ""), true},
+		{errors.New("unknown"), true},
+		{status.Error(codes.InvalidArgument, ""), false},
+		{status.Error(codes.NotFound, ""), false},
+	}
+	for _, tt := range tests {
+		if got := IsFailure(tt.err); got != tt.want {
+			t.Errorf("IsFailure(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	g := NewGroup(func() Breaker {
+		return NewBreaker(WithMinRequests(3))
+	})
+	failing := func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.Unavailable, "down")
+	}
+	h := Client(WithGroup(g))(failing)
+
+	ctx := middleware.NewOperationContext(context.Background(), "/helloworld.Greeter/SayHello")
+	for i := 0; i < 3; i++ {
+		if _, err := h(ctx, nil); status.Code(err) != codes.Unavailable || err == ErrNotAllowed {
+			t.Fatalf("expect the backend error, got %v", err)
+		}
+	}
+	if _, err := h(ctx, nil); err != ErrNotAllowed {
+		t.Fatalf("expect ErrNotAllowed, got %v", err)
+	}
+	if status.Code(ErrNotAllowed) != codes.Unavailable {
+		t.Errorf("expect Unavailable, got %v", status.Code(ErrNotAllowed))
+	}
+
+	// other methods are not affected
+	other := middleware.NewOperationContext(context.Background(), "/helloworld.Greeter/SayBye")
+	if _, err := h(other, nil); err == ErrNotAllowed {
+		t.Fatal("expect allowed")
+	}
+
+	states := g.States()
+	if states["/helloworld.Greeter/SayHello"] != StateOpen || states["/helloworld.Greeter/SayBye"] != StateClosed {
+		t.Errorf("unexpected states %v", states)
+	}
+}
+
+func TestClient_PerTarget(t *testing.T) {
+	g := NewGroup(func() Breaker {
+		return NewBreaker(WithMinRequests(1))
+	})
+	h := Client(WithGroup(g), WithPerTarget())(func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.Internal, "")
+	})
+	ctx := middleware.NewOperationContext(context.Background(), "/helloworld.Greeter/SayHello")
+	_, _ = h(middleware.NewTargetContext(ctx, "discovery:///a"), nil)
+	if _, err := h(middleware.NewTargetContext(ctx, "discovery:///b"), nil); err == ErrNotAllowed {
+		t.Fatal("expect the breaker of another target closed")
+	}
+	if _, err := h(middleware.NewTargetContext(ctx, "discovery:///a"), nil); err != ErrNotAllowed {
+		t.Fatalf("expect ErrNotAllowed, got %v", err)
+	}
+}
+
+func TestClient_IgnoreRequestErrors(t *testing.T) {
+	g := NewGroup(func() Breaker {
+		return NewBreaker(WithMinRequests(1))
+	})
+	h := Client(WithGroup(g))(func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.InvalidArgument, "")
+	})
+	for i := 0; i < 10; i++ {
+		if _, err := h(context.Background(), nil); err == ErrNotAllowed {
+			t.Fatal("expect request errors not to open the breaker")
+		}
+	}
+}
+
+func TestClient_CanceledProbe(t *testing.T) {
+	b := NewBreaker(WithMinRequests(1), WithProbes(1), WithOpenTimeout(0))
+	g := NewGroup(func() Breaker { return b })
+	var code codes.Code
+	h := Client(WithGroup(g))(func(ctx context.Context, req any) (any, error) {
+		if code == codes.OK {
+			return nil, nil
+		}
+		return nil, status.Error(code, "")
+	})
+
+	code = codes.Unavailable
+	_, _ = h(context.Background(), nil)
+	if b.State() != StateHalfOpen {
+		t.Fatalf("expect half-open, got %v", b.State())
+	}
+	// a canceled probe neither closes nor opens the breaker, and frees
+	// the probe for the next call
+	code = codes.Canceled
+	_, _ = h(context.Background(), nil)
+	if b.State() != StateHalfOpen {
+		t.Fatalf("expect half-open after a canceled probe, got %v", b.State())
+	}
+	code = codes.OK
+	if _, err := h(context.Background(), nil); err != nil {
+		t.Fatalf("expect probe allowed, got %v", err)
+	}
+	if b.State() != StateClosed {
+		t.Fatalf("expect closed, got %v", b.State())
+	}
+}
+
+func TestClient_PerBackend(t *testing.T) {
+	g := NewGroup(func() Breaker {
+		return NewBreaker(WithMinRequests(1))
+	})
+	// the handler picks the backends in turn like a balancer would, the
+	// backend a fails
+	var picks []string
+	h := Client(WithGroup(g), WithPerBackend())(func(ctx context.Context, req any) (any, error) {
+		pick, ok := middleware.PickFromContext(ctx)
+		if !ok {
+			t.Fatal("expect a PickFunc in context")
+		}
+		for _, addr := range []string{"a", "b"} {
+			done, err := pick(addr)
+			if err != nil {
+				continue
+			}
+			picks = append(picks, addr)
+			if addr == "a" {
+				done(status.Error(codes.Unavailable, ""))
+				return nil, status.Error(codes.Unavailable, "")
+			}
+			done(nil)
+			return nil, nil
+		}
+		return nil, ErrNotAllowed
+	})
+	ctx := middleware.NewOperationContext(context.Background(), "/helloworld.Greeter/SayHello")
+	_, _ = h(ctx, nil)
+	for i := 0; i < 3; i++ {
+		if _, err := h(ctx, nil); err != nil {
+			t.Fatalf("expect the backend b to serve, got %v", err)
+		}
+	}
+	if len(picks) != 4 || picks[0] != "a" || picks[3] != "b" || picks[1] != "b" {
+		t.Errorf("unexpected picks %v", picks)
+	}
+	states := g.States()
+	if states["a/helloworld.Greeter/SayHello"] != StateOpen || states["b/helloworld.Greeter/SayHello"] != StateClosed {
+		t.Errorf("unexpected states %v", states)
+	}
+}
@@ -0,0 +1,109 @@
+package circuitbreaker
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SREOption is adaptive throttling breaker option.
+type SREOption func(*sreBreaker)
+
+// WithK with the multiplier of the accepted requests, a lower K throttles
+// more aggressively, default is 2.
+func WithK(k float64) SREOption {
+	return func(b *sreBreaker) {
+		b.k = k
+	}
+}
+
+// WithSREWindow with the rolling window of the requests, default is 10s.
+func WithSREWindow(d time.Duration) SREOption {
+	return func(b *sreBreaker) {
+		b.window = newWindow(d, 10)
+	}
+}
+
+// WithSREMinRequests with the minimum requests in the window before the
+// breaker may throttle, default is 100.
+func WithSREMinRequests(n int64) SREOption {
+	return func(b *sreBreaker) {
+		b.minRequests = n
+	}
+}
+
+// sreBreaker implements the client-side adaptive throttling of the Google
+// SRE book: a request is rejected locally with the probability
+// max(0, (requests - K * accepts) / (requests + 1)), so the load on a
+// failing backend decreases smoothly instead of being cut off.
+type sreBreaker struct {
+	window      *window
+	k           float64
+	minRequests int64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewSREBreaker creates an adaptive throttling breaker, it reports
+// StateOpen while it rejects requests and StateClosed otherwise.
+func NewSREBreaker(opts ...SREOption) Breaker {
+	b := &sreBreaker{
+		window:      newWindow(10*time.Second, 10),
+		k:           2,
+		minRequests: 100,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	return b
+}
+
+// Allow implements Breaker, the adaptive throttling has no states, so the
+// generation is always 0.
+func (b *sreBreaker) Allow() (uint64, bool) {
+	p := b.rejectProbability()
+	if p <= 0 {
+		return 0, true
+	}
+	b.mu.Lock()
+	reject := b.rand.Float64() < p
+	b.mu.Unlock()
+	if reject {
+		// the rejected requests count as not accepted
+		b.window.add(true)
+		return 0, false
+	}
+	return 0, true
+}
+
+func (b *sreBreaker) MarkSuccess(uint64) {
+	b.window.add(false)
+}
+
+func (b *sreBreaker) MarkFailed(uint64) {
+	b.window.add(true)
+}
+
+func (b *sreBreaker) Release(uint64) {}
+
+func (b *sreBreaker) State() State {
+	if b.rejectProbability() > 0 {
+		return StateOpen
+	}
+	return StateClosed
+}
+
+func (b *sreBreaker) rejectProbability() float64 {
+	requests, failures := b.window.sum()
+	if requests < b.minRequests {
+		return 0
+	}
+	accepts := requests - failures
+	p := (float64(requests) - b.k*float64(accepts)) / float64(requests+1)
+	if p < 0 {
+		return 0
+	}
+	return p
+}
@@ -0,0 +1,75 @@
+package circuitbreaker
+
+import (
+	"sync"
+	"time"
+)
+
+// window is a rolling window of request counts split in buckets, the
+// buckets older than the window are reset as time goes by.
+type window struct {
+	mu      sync.Mutex
+	size    time.Duration
+	buckets []bucket
+	// now is replaced in tests
+	now func() time.Time
+}
+
+type bucket struct {
+	start    int64
+	requests int64
+	failures int64
+}
+
+func newWindow(size time.Duration, buckets int) *window {
+	return &window{
+		size:    size,
+		buckets: make([]bucket, buckets),
+		now:     time.Now,
+	}
+}
+
+// add records a request in the current bucket.
+func (w *window) add(failed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b := w.current()
+	b.requests++
+	if failed {
+		b.failures++
+	}
+}
+
+// sum returns the requests and the failures in the window.
+func (w *window) sum() (requests, failures int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	oldest := w.now().UnixNano() - int64(w.size)
+	for _, b := range w.buckets {
+		if b.start > oldest {
+			requests += b.requests
+			failures += b.failures
+		}
+	}
+	return requests, failures
+}
+
+// reset clears the window.
+func (w *window) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i := range w.buckets {
+		w.buckets[i] = bucket{}
+	}
+}
+
+func (w *window) current() *bucket {
+	width := int64(w.size) / int64(len(w.buckets))
+	now := w.now().UnixNano()
+	start := now - now%width
+	b := &w.buckets[(now/width)%int64(len(w.buckets))]
+	if b.start != start {
+		*b = bucket{start: start}
+	}
+	return b
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"errors"
 )
 
 // Handler defines the handler invoked by Middleware.
@@ -19,3 +20,93 @@ func Chain(m ...Middleware) Middleware {
 		return next
 	}
 }
+
+type (
+	operationKey struct{}
+	targetKey    struct{}
+	pickKey      struct{}
+)
+
+// NewOperationContext returns a new Context that carries the operation,
+// the full gRPC method name of the call.
+func NewOperationContext(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, operationKey{}, operation)
+}
+
+// OperationFromContext returns the operation stored in ctx, if any.
+func OperationFromContext(ctx context.Context) (operation string, ok bool) {
+	operation, ok = ctx.Value(operationKey{}).(string)
+	return
+}
+
+// NewTargetContext returns a new Context that carries the target the client
+// dials, such as discovery:///helloworld.
+func NewTargetContext(ctx context.Context, target string) context.Context {
+	return context.WithValue(ctx, targetKey{}, target)
+}
+
+// TargetFromContext returns the target stored in ctx, if any.
+func TargetFromContext(ctx context.Context) (target string, ok bool) {
+	target, ok = ctx.Value(targetKey{}).(string)
+	return
+}
+
+// ErrNotSent is passed to the done func of a PickFunc when the call was not
+// sent to the picked backend, such as when its connection broke meanwhile.
+var ErrNotSent = errors.New("middleware: call not sent to the picked backend")
+
+// PickFunc is called when the balancer picks the backend at addr for a call,
+// a non nil error rejects the backend. The returned done, if any, is called
+// with the error of the call once it ends, or ErrNotSent.
+type PickFunc func(addr string) (done func(err error), err error)
+
+// NewPickContext returns a new Context that carries the PickFunc of the
+// call, it is called by the balancers of the gRPC client.
+func NewPickContext(ctx context.Context, fn PickFunc) context.Context {
+	return context.WithValue(ctx, pickKey{}, fn)
+}
+
+// PickFromContext returns the PickFunc stored in ctx, if any.
+func PickFromContext(ctx context.Context) (fn PickFunc, ok bool) {
+	fn, ok = ctx.Value(pickKey{}).(PickFunc)
+	return fn, ok && fn != nil
+}
+
+// Stream is the message stream of a streaming call.
+//
+// The middleware run once around every streaming call, from the opening of
+// the stream to its end, with a nil request; the stream of the call is
+// carried by the context, and a middleware may replace it to intercept the
+// messages, see WrapStream.
+type Stream interface {
+	Context() context.Context
+	SendMsg(m any) error
+	RecvMsg(m any) error
+}
+
+type streamKey struct{}
+
+// NewStreamContext returns a new Context that carries the stream.
+func NewStreamContext(ctx context.Context, s Stream) context.Context {
+	return context.WithValue(ctx, streamKey{}, s)
+}
+
+// StreamFromContext returns the stream stored in ctx, if any.
+func StreamFromContext(ctx context.Context) (s Stream, ok bool) {
+	s, ok = ctx.Value(streamKey{}).(Stream)
+	return
+}
+
+// WrapStream returns a Middleware which wraps the stream of the streaming
+// calls with wrap, such as to count or log the messages, the unary calls
+// are passed through.
+func WrapStream(wrap func(ctx context.Context, s Stream) Stream) Middleware {
+	return func(handler Handler) Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			if s, ok := StreamFromContext(ctx); ok {
+				ctx = NewStreamContext(ctx, wrap(ctx, s))
+			}
+			return handler(ctx, req)
+		}
+	}
+}
@@ -57,3 +57,62 @@ func test3Middleware(handler Handler) Handler {
 		return
 	}
 }
+
+func TestOperationContext(t *testing.T) {
+	if _, ok := OperationFromContext(context.Background()); ok {
+		t.Error("expect no operation")
+	}
+	ctx := NewOperationContext(context.Background(), "/helloworld.Greeter/SayHello")
+	if op, ok := OperationFromContext(ctx); !ok || op != "/helloworld.Greeter/SayHello" {
+		t.Errorf("expect /helloworld.Greeter/SayHello, got %v", op)
+	}
+	ctx = NewTargetContext(ctx, "discovery:///helloworld")
+	if target, ok := TargetFromContext(ctx); !ok || target != "discovery:///helloworld" {
+		t.Errorf("expect discovery:///helloworld, got %v", target)
+	}
+}
+
+type testStream struct {
+	Stream
+	sent []any
+}
+
+func (s *testStream) SendMsg(m any) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+type prefixStream struct {
+	Stream
+	prefix string
+}
+
+func (s *prefixStream) SendMsg(m any) error {
+	return s.Stream.SendMsg(s.prefix + m.(string))
+}
+
+func TestWrapStream(t *testing.T) {
+	m := WrapStream(func(ctx context.Context, s Stream) Stream {
+		return &prefixStream{Stream: s, prefix: "> "}
+	})
+	handler := func(ctx context.Context, req any) (any, error) {
+		s, ok := StreamFromContext(ctx)
+		if !ok {
+			return "unary", nil
+		}
+		return nil, s.SendMsg("hello")
+	}
+
+	// unary calls are passed through
+	if reply, _ := m(handler)(context.Background(), "req"); reply != "unary" {
+		t.Errorf("expect unary, got %v", reply)
+	}
+
+	s := &testStream{}
+	if _, err := m(handler)(NewStreamContext(context.Background(), s), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.sent, []any{"> hello"}) {
+		t.Errorf("expect [> hello], got %v", s.sent)
+	}
+}
@@ -0,0 +1,66 @@
+package retry
+
+import (
+	"sync"
+)
+
+// Budget is a token bucket limiting the retries of a client, as the retry
+// throttling of gRPC: every retryable failure takes a token, every success
+// gives back ratio tokens, and the calls are not retried while the bucket
+// is at most half full. It prevents retry storms from amplifying the load
+// on an overloaded backend.
+type Budget struct {
+	mu        sync.Mutex
+	tokens    float64
+	maxTokens float64
+	ratio     float64
+}
+
+// NewBudget creates a full budget of maxTokens tokens.
+func NewBudget(maxTokens, ratio float64) *Budget {
+	return &Budget{
+		tokens:    maxTokens,
+		maxTokens: maxTokens,
+		ratio:     ratio,
+	}
+}
+
+// Tokens returns the tokens left in the budget.
+func (b *Budget) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens
+}
+
+func (b *Budget) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens > b.maxTokens/2
+}
+
+func (b *Budget) onSuccess() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.maxTokens {
+		b.tokens = b.maxTokens
+	}
+}
+
+func (b *Budget) onFailure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens--
+	if b.tokens < 0 {
+		b.tokens = 0
+	}
+}
@@ -0,0 +1,154 @@
+// Package retry provides a client middleware which retries the failed calls
+// according to per-method policies, within a retry budget and the deadline
+// of the call.
+package retry
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/apus-run/gaea/internal/backoff"
+	"github.com/apus-run/gaea/internal/selector"
+	"github.com/apus-run/gaea/middleware"
+)
+
+// Policy is the retry policy of a method.
+type Policy struct {
+	// MaxAttempts is the maximum number of attempts, including the
+	// original call. A policy with less than 2 attempts disables retries.
+	MaxAttempts int
+	// Codes are the status codes which are retried.
+	Codes []codes.Code
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the upper bound of the delay between retries.
+	MaxBackoff time.Duration
+	// BackoffMultiplier is the factor with which the delay grows after
+	// every retry.
+	BackoffMultiplier float64
+	// Jitter is the factor with which the delays are randomized.
+	Jitter float64
+}
+
+// DefaultPolicy retries Unavailable calls up to 3 attempts, it is opt-in
+// with WithDefaultPolicy(DefaultPolicy) since retrying a call which is not
+// idempotent may apply it twice.
+var DefaultPolicy = Policy{
+	MaxAttempts:       3,
+	Codes:             []codes.Code{codes.Unavailable},
+	InitialBackoff:    100 * time.Millisecond,
+	MaxBackoff:        time.Second,
+	BackoffMultiplier: 2,
+	Jitter:            0.2,
+}
+
+func (p Policy) retryable(err error) bool {
+	code := status.Code(err)
+	for _, c := range p.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) backoff(retries int) time.Duration {
+	return backoff.Exponential{
+		BaseDelay:  p.InitialBackoff,
+		Multiplier: p.BackoffMultiplier,
+		Jitter:     p.Jitter,
+		MaxDelay:   p.MaxBackoff,
+	}.Backoff(retries)
+}
+
+// Option is retry option.
+type Option func(*options)
+
+type options struct {
+	defaults Policy
+	policies selector.Selector[Policy]
+	budget   *Budget
+}
+
+// WithDefaultPolicy with the policy of the methods without their own
+// policy, default is no retry.
+func WithDefaultPolicy(p Policy) Option {
+	return func(o *options) {
+		o.defaults = p
+	}
+}
+
+// WithPolicy with the policy of the methods matching the selector, such as
+// /helloworld.Greeter/SayHello for a method or /helloworld.Greeter/* for a
+// service.
+func WithPolicy(selector string, p Policy) Option {
+	return func(o *options) {
+		o.policies.Add(selector, p)
+	}
+}
+
+// WithBudget with the retry budget shared by all the methods, default is
+// no budget.
+func WithBudget(b *Budget) Option {
+	return func(o *options) {
+		o.budget = b
+	}
+}
+
+func (o *options) match(operation string) Policy {
+	if p, ok := o.policies.Match(operation); ok {
+		return p
+	}
+	return o.defaults
+}
+
+// Client is a client middleware which retries the failed calls of the
+// methods with a retry policy, see WithDefaultPolicy and WithPolicy. A
+// retry is skipped when the budget is exhausted or when the remaining
+// deadline of the call is shorter than the backoff. Streams are never
+// retried, the messages already exchanged cannot be replayed.
+func Client(opts ...Option) middleware.Middleware {
+	o := options{
+		defaults: Policy{MaxAttempts: 1},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			if _, ok := middleware.StreamFromContext(ctx); ok {
+				return handler(ctx, req)
+			}
+			operation, _ := middleware.OperationFromContext(ctx)
+			p := o.match(operation)
+			for attempt := 1; ; attempt++ {
+				reply, err := handler(ctx, req)
+				if err == nil {
+					o.budget.onSuccess()
+					return reply, nil
+				}
+				if !p.retryable(err) {
+					return reply, err
+				}
+				o.budget.onFailure()
+				if attempt >= p.MaxAttempts || !o.budget.allow() {
+					return reply, err
+				}
+				delay := p.backoff(attempt - 1)
+				if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+					return reply, err
+				}
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return reply, err
+				case <-timer.C:
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package retry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/apus-run/gaea/middleware"
+)
+
+var fastPolicy = Policy{
+	MaxAttempts:       3,
+	Codes:             []codes.Code{codes.Unavailable},
+	InitialBackoff:    time.Millisecond,
+	MaxBackoff:        time.Millisecond,
+	BackoffMultiplier: 2,
+}
+
+// failing returns a handler failing with code n times before succeeding.
+func failing(code codes.Code, n int, calls *int) middleware.Handler {
+	return func(ctx context.Context, req any) (any, error) {
+		*calls++
+		if *calls <= n {
+			return nil, status.Error(code, "")
+		}
+		return "reply", nil
+	}
+}
+
+func TestClient(t *testing.T) {
+	var calls int
+	h := Client(WithDefaultPolicy(fastPolicy))(failing(codes.Unavailable, 2, &calls))
+	reply, err := h(context.Background(), nil)
+	if err != nil || reply != "reply" {
+		t.Fatalf("expect reply, got %v %v", reply, err)
+	}
+	if calls != 3 {
+		t.Errorf("expect 3 calls, got %d", calls)
+	}
+
+	// max attempts
+	calls = 0
+	h = Client(WithDefaultPolicy(fastPolicy))(failing(codes.Unavailable, 5, &calls))
+	if _, err = h(context.Background(), nil); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expect Unavailable, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expect 3 calls, got %d", calls)
+	}
+
+	// not retryable
+	calls = 0
+	h = Client(WithDefaultPolicy(fastPolicy))(failing(codes.InvalidArgument, 5, &calls))
+	if _, err = h(context.Background(), nil); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expect InvalidArgument, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expect 1 call, got %d", calls)
+	}
+
+	// no retry by default
+	calls = 0
+	h = Client()(failing(codes.Unavailable, 5, &calls))
+	if _, err = h(context.Background(), nil); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expect Unavailable, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expect 1 call, got %d", calls)
+	}
+}
+
+func TestClient_Policies(t *testing.T) {
+	service := fastPolicy
+	service.MaxAttempts = 2
+	method := fastPolicy
+	method.MaxAttempts = 4
+	noRetry := Policy{MaxAttempts: 1}
+	m := Client(
+		WithDefaultPolicy(noRetry),
+		WithPolicy("/helloworld.Greeter/*", service),
+		WithPolicy("/helloworld.Greeter/SayHello", method),
+	)
+
+	tests := []struct {
+		operation string
+		calls     int
+	}{
+		{"/helloworld.Greeter/SayHello", 4},
+		{"/helloworld.Greeter/SayBye", 2},
+		{"/echo.Echo/Echo", 1},
+	}
+	for _, tt := range tests {
+		var calls int
+		ctx := middleware.NewOperationContext(context.Background(), tt.operation)
+		_, _ = m(failing(codes.Unavailable, 10, &calls))(ctx, nil)
+		if calls != tt.calls {
+			t.Errorf("%s: expect %d calls, got %d", tt.operation, tt.calls, calls)
+		}
+	}
+}
+
+func TestClient_Budget(t *testing.T) {
+	b := NewBudget(4, 0.5)
+	var calls int
+	h := Client(WithDefaultPolicy(fastPolicy), WithBudget(b))(failing(codes.Unavailable, 100, &calls))
+
+	// 4 tokens: the first failure leaves 3 tokens and allows a retry, the
+	// second leaves 2 tokens which is not above half of the bucket
+	_, _ = h(context.Background(), nil)
+	if calls != 2 {
+		t.Errorf("expect 2 calls, got %d", calls)
+	}
+	calls = 0
+	_, _ = h(context.Background(), nil)
+	if calls != 1 {
+		t.Errorf("expect no retry with an exhausted budget, got %d calls", calls)
+	}
+
+	// successes refill the budget
+	ok := Client(WithBudget(b))(func(ctx context.Context, req any) (any, error) {
+		return "reply", nil
+	})
+	for i := 0; i < 10; i++ {
+		_, _ = ok(context.Background(), nil)
+	}
+	if b.Tokens() != 4 {
+		t.Errorf("expect 4 tokens, got %v", b.Tokens())
+	}
+}
+
+func TestClient_Deadline(t *testing.T) {
+	slow := fastPolicy
+	slow.InitialBackoff = time.Second
+	slow.MaxBackoff = time.Second
+
+	var calls int
+	h := Client(WithDefaultPolicy(slow))(failing(codes.Unavailable, 10, &calls))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := h(ctx, nil); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expect Unavailable, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expect no retry past the deadline, got %d calls", calls)
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Errorf("expect to give up immediately, took %v", time.Since(start))
+	}
+}
+
+type stream struct {
+	middleware.Stream
+}
+
+func TestClient_Stream(t *testing.T) {
+	var calls int
+	h := Client(WithDefaultPolicy(fastPolicy))(failing(codes.Unavailable, 2, &calls))
+	ctx := middleware.NewStreamContext(context.Background(), &stream{})
+	if _, err := h(ctx, nil); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expect Unavailable, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expect the stream not retried, got %d calls", calls)
+	}
+}
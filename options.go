@@ -30,7 +30,17 @@ type options struct {
 	registry        registry.Registry
 	registryTimeout time.Duration
 	stopTimeout     time.Duration
+	hookTimeout     time.Duration
+	readyTimeout    time.Duration
 	servers         []server.Server
+	components      []Component
+
+	// readinessProbes must pass before the service is registered
+	readinessProbes []func(context.Context) error
+
+	// registryHeartbeat is the interval of re-registering the instance, zero disables it
+	registryHeartbeat    time.Duration
+	registryErrorHandler func(error)
 
 	// Before and After funcs
 	beforeStart []func(context.Context) error
@@ -46,6 +56,8 @@ func defaultOptions() *options {
 		sigs:            []os.Signal{syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGINT},
 		registryTimeout: 10 * time.Second,
 		stopTimeout:     10 * time.Second,
+		hookTimeout:     10 * time.Second,
+		readyTimeout:    10 * time.Second,
 	}
 }
 
@@ -110,9 +122,19 @@ func WithEndpoint(endpoints ...*url.URL) Option {
 }
 
 // WithRegistry with service registry.
-func WithRegistry(r registry.Registry) Option {
+// Several registries are combined with registry.NewMultiRegistry and a
+// failure in any of them fails the registration, build the multi registry
+// with a registry.FailurePolicy to only report partial failures.
+func WithRegistry(r ...registry.Registry) Option {
 	return func(o *options) {
-		o.registry = r
+		switch len(r) {
+		case 0:
+			o.registry = nil
+		case 1:
+			o.registry = r[0]
+		default:
+			o.registry = registry.NewMultiRegistry(r)
+		}
 	}
 }
 
@@ -123,6 +145,14 @@ func WithServer(srv ...server.Server) Option {
 	}
 }
 
+// WithComponent with components, they are started in dependency order
+// before the servers and stopped in reverse order after the servers.
+func WithComponent(c ...Component) Option {
+	return func(o *options) {
+		o.components = append(o.components, c...)
+	}
+}
+
 // WithRegistryTimeout with registrar timeout.
 func WithRegistryTimeout(t time.Duration) Option {
 	return func(o *options) {
@@ -130,6 +160,21 @@ func WithRegistryTimeout(t time.Duration) Option {
 	}
 }
 
+// WithRegistryHeartbeat keeps the registration alive by re-registering the
+// service instance every interval, failures are retried with backoff.
+func WithRegistryHeartbeat(interval time.Duration) Option {
+	return func(o *options) {
+		o.registryHeartbeat = interval
+	}
+}
+
+// WithRegistryErrorHandler with the handler of re-registration errors.
+func WithRegistryErrorHandler(h func(error)) Option {
+	return func(o *options) {
+		o.registryErrorHandler = h
+	}
+}
+
 // WithStopTimeout with app stop timeout.
 func WithStopTimeout(t time.Duration) Option {
 	return func(o *options) {
@@ -137,6 +182,30 @@ func WithStopTimeout(t time.Duration) Option {
 	}
 }
 
+// WithHookTimeout with the timeout of each stop hook phase (BeforeStop and AfterStop).
+// Zero means no timeout.
+func WithHookTimeout(t time.Duration) Option {
+	return func(o *options) {
+		o.hookTimeout = t
+	}
+}
+
+// WithReadyTimeout with the timeout of waiting for servers ready and
+// readiness probes passed. Zero means no timeout.
+func WithReadyTimeout(t time.Duration) Option {
+	return func(o *options) {
+		o.readyTimeout = t
+	}
+}
+
+// WithReadinessProbe with readiness probes, such as warming caches or pinging
+// the DB, which must pass before the service is registered.
+func WithReadinessProbe(probes ...func(context.Context) error) Option {
+	return func(o *options) {
+		o.readinessProbes = append(o.readinessProbes, probes...)
+	}
+}
+
 // Before and Afters
 
 // BeforeStart run funcs before app starts
@@ -117,6 +117,27 @@ func TestRegistrar(t *testing.T) {
 	}
 }
 
+func TestComponent(t *testing.T) {
+	o := &options{}
+	v := []Component{&mockComponent{name: "a"}, &mockComponent{name: "b"}}
+	WithComponent(v[0])(o)
+	WithComponent(v[1])(o)
+	if !reflect.DeepEqual(v, o.components) {
+		t.Fatal("o.components is not equal to v")
+	}
+}
+
+func TestMultiRegistrar(t *testing.T) {
+	o := &options{}
+	WithRegistry(&mockRegistrar{}, &mockRegistrar{})(o)
+	if o.registry == nil {
+		t.Fatal("o.registrar is nil")
+	}
+	if err := o.registry.Register(context.Background(), &registry.ServiceInstance{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestRegistrarTimeout(t *testing.T) {
 	o := &options{}
 	v := time.Duration(123)
@@ -126,6 +147,23 @@ func TestRegistrarTimeout(t *testing.T) {
 	}
 }
 
+func TestRegistryHeartbeat(t *testing.T) {
+	o := &options{}
+	v := time.Duration(123)
+	WithRegistryHeartbeat(v)(o)
+	if !reflect.DeepEqual(v, o.registryHeartbeat) {
+		t.Fatal("o.registryHeartbeat is not equal to v")
+	}
+}
+
+func TestRegistryErrorHandler(t *testing.T) {
+	o := &options{}
+	WithRegistryErrorHandler(func(error) {})(o)
+	if o.registryErrorHandler == nil {
+		t.Fatal("o.registryErrorHandler is nil")
+	}
+}
+
 func TestStopTimeout(t *testing.T) {
 	o := &options{}
 	v := time.Duration(123)
@@ -135,6 +173,33 @@ func TestStopTimeout(t *testing.T) {
 	}
 }
 
+func TestHookTimeout(t *testing.T) {
+	o := &options{}
+	v := time.Duration(123)
+	WithHookTimeout(v)(o)
+	if !reflect.DeepEqual(v, o.hookTimeout) {
+		t.Fatal("o.hookTimeout is not equal to v")
+	}
+}
+
+func TestReadyTimeout(t *testing.T) {
+	o := &options{}
+	v := time.Duration(123)
+	WithReadyTimeout(v)(o)
+	if !reflect.DeepEqual(v, o.readyTimeout) {
+		t.Fatal("o.readyTimeout is not equal to v")
+	}
+}
+
+func TestReadinessProbe(t *testing.T) {
+	o := &options{}
+	v := func(context.Context) error { return nil }
+	WithReadinessProbe(v, v)(o)
+	if len(o.readinessProbes) != 2 {
+		t.Fatalf("expect 2 readiness probes, got %d", len(o.readinessProbes))
+	}
+}
+
 func TestBeforeStart(t *testing.T) {
 	o := &options{}
 	v := func(_ context.Context) error {
@@ -0,0 +1,242 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "google.golang.org/grpc/grpclog"
+
+	"github.com/apus-run/gaea/internal/backoff"
+	"github.com/apus-run/gaea/registry"
+)
+
+var _ registry.Discovery = (*Discovery)(nil)
+
+// Discovery is a caching registry.Discovery decorator.
+// It shares one upstream watch per service name among all the watchers of
+// that service, serves GetService from the cache while the service is
+// watched, and keeps the last known good instances when the upstream is
+// unavailable.
+type Discovery struct {
+	upstream registry.Discovery
+	backoff  backoff.Exponential
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// entry is the cache of one service.
+type entry struct {
+	// ins is the last known good instances, valid when loaded is true.
+	ins    []*registry.ServiceInstance
+	loaded bool
+
+	// starting is closed once the upstream watch being created is ready or
+	// failed, it is nil when no upstream watch is being created.
+	starting chan struct{}
+
+	// the upstream watch, running while there are subscribers
+	uw     registry.Watcher
+	cancel context.CancelFunc
+	done   chan struct{}
+	subs   map[*watcher]struct{}
+}
+
+// New creates a caching discovery in front of d.
+func New(d registry.Discovery) *Discovery {
+	return &Discovery{
+		upstream: d,
+		backoff:  backoff.DefaultExponential,
+		entries:  make(map[string]*entry),
+	}
+}
+
+// GetService returns the cached instances while the service is watched,
+// otherwise it asks the upstream and falls back to the last known good
+// instances if the upstream fails.
+func (d *Discovery) GetService(ctx context.Context, serviceName string) ([]*registry.ServiceInstance, error) {
+	d.mu.Lock()
+	e, ok := d.entries[serviceName]
+	if ok && e.loaded && e.done != nil {
+		ins := e.ins
+		d.mu.Unlock()
+		return ins, nil
+	}
+	d.mu.Unlock()
+
+	ins, err := d.upstream.GetService(ctx, serviceName)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e = d.entry(serviceName)
+	if err != nil {
+		if e.loaded {
+			return e.ins, nil
+		}
+		return nil, err
+	}
+	e.ins, e.loaded = ins, true
+	return ins, nil
+}
+
+// GetServiceList returns the instances of all the services from the upstream.
+func (d *Discovery) GetServiceList(ctx context.Context) ([]*registry.ServiceInstance, error) {
+	return d.upstream.GetServiceList(ctx)
+}
+
+// Watch creates a watcher of the service, the upstream is only watched
+// once no matter how many watchers there are.
+func (d *Discovery) Watch(ctx context.Context, serviceName string) (registry.Watcher, error) {
+	d.mu.Lock()
+	e := d.entry(serviceName)
+	for e.done == nil {
+		if e.starting != nil {
+			// another caller is creating the upstream watch, wait for it
+			starting := e.starting
+			d.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-starting:
+			}
+			d.mu.Lock()
+			continue
+		}
+
+		// create the upstream watch without holding the lock, the upstream
+		// may block on the network
+		starting := make(chan struct{})
+		e.starting = starting
+		d.mu.Unlock()
+		uctx, cancel := context.WithCancel(context.Background())
+		uw, err := d.upstream.Watch(uctx, serviceName)
+		d.mu.Lock()
+		e.starting = nil
+		close(starting)
+		if err != nil {
+			d.mu.Unlock()
+			cancel()
+			return nil, err
+		}
+		e.uw = uw
+		e.cancel = cancel
+		e.done = make(chan struct{})
+		go d.watch(uctx, serviceName, e, uw, e.done)
+	}
+	defer d.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	w := &watcher{
+		d:           d,
+		serviceName: serviceName,
+		ctx:         ctx,
+		cancel:      cancel,
+		event:       make(chan struct{}, 1),
+	}
+	e.subs[w] = struct{}{}
+	if e.loaded && len(e.ins) > 0 {
+		w.event <- struct{}{}
+	}
+	return w, nil
+}
+
+// entry must be called with d.mu held.
+func (d *Discovery) entry(serviceName string) *entry {
+	e, ok := d.entries[serviceName]
+	if !ok {
+		e = &entry{subs: make(map[*watcher]struct{})}
+		d.entries[serviceName] = e
+	}
+	return e
+}
+
+// watch reads the upstream watcher and fans the instances out to the
+// subscribers until the last subscriber stops.
+func (d *Discovery) watch(ctx context.Context, serviceName string, e *entry, uw registry.Watcher, done chan struct{}) {
+	defer close(done)
+
+	failures := 0
+	for {
+		ins, err := uw.Next()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+				return
+			}
+			// keep serving the last known good instances
+			log.Errorf("[cache] failed to watch %s: %v", serviceName, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d.backoff.Backoff(failures)):
+			}
+			failures++
+			continue
+		}
+		failures = 0
+
+		d.mu.Lock()
+		e.ins, e.loaded = ins, true
+		for w := range e.subs {
+			select {
+			case w.event <- struct{}{}:
+			default:
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+func (d *Discovery) unsubscribe(w *watcher) {
+	d.mu.Lock()
+	e, ok := d.entries[w.serviceName]
+	if !ok {
+		d.mu.Unlock()
+		return
+	}
+	delete(e.subs, w)
+	if len(e.subs) > 0 || e.done == nil {
+		d.mu.Unlock()
+		return
+	}
+	uw, cancel, done := e.uw, e.cancel, e.done
+	e.uw, e.cancel, e.done = nil, nil, nil
+	d.mu.Unlock()
+
+	// the cached instances are kept as the last known good ones
+	cancel()
+	if err := uw.Stop(); err != nil {
+		log.Errorf("[cache] failed to stop watching %s: %v", w.serviceName, err)
+	}
+	<-done
+}
+
+type watcher struct {
+	d           *Discovery
+	serviceName string
+	ctx         context.Context
+	cancel      context.CancelFunc
+	event       chan struct{}
+	stopOnce    sync.Once
+}
+
+// Next implements registry.Watcher.
+func (w *watcher) Next() ([]*registry.ServiceInstance, error) {
+	select {
+	case <-w.ctx.Done():
+		return nil, w.ctx.Err()
+	case <-w.event:
+	}
+	w.d.mu.Lock()
+	defer w.d.mu.Unlock()
+	return w.d.entries[w.serviceName].ins, nil
+}
+
+// Stop implements registry.Watcher.
+func (w *watcher) Stop() error {
+	w.stopOnce.Do(func() {
+		w.cancel()
+		w.d.unsubscribe(w)
+	})
+	return nil
+}
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apus-run/gaea/registry"
+	"github.com/apus-run/gaea/registry/memory"
+)
+
+type countingDiscovery struct {
+	*memory.Registry
+
+	lk          sync.Mutex
+	watches     int
+	gets        int
+	unavailable bool
+	// block delays Watch until it is closed, when set
+	block chan struct{}
+}
+
+func (d *countingDiscovery) GetService(ctx context.Context, serviceName string) ([]*registry.ServiceInstance, error) {
+	d.lk.Lock()
+	d.gets++
+	down := d.unavailable
+	d.lk.Unlock()
+	if down {
+		return nil, errors.New("registry unavailable")
+	}
+	return d.Registry.GetService(ctx, serviceName)
+}
+
+func (d *countingDiscovery) Watch(ctx context.Context, serviceName string) (registry.Watcher, error) {
+	d.lk.Lock()
+	d.watches++
+	block := d.block
+	d.lk.Unlock()
+	if block != nil {
+		<-block
+	}
+	w, err := d.Registry.Watch(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyWatcher{Watcher: w, d: d}, nil
+}
+
+func (d *countingDiscovery) setUnavailable(v bool) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.unavailable = v
+}
+
+type flakyWatcher struct {
+	registry.Watcher
+	d *countingDiscovery
+}
+
+func (w *flakyWatcher) Next() ([]*registry.ServiceInstance, error) {
+	ins, err := w.Watcher.Next()
+	w.d.lk.Lock()
+	defer w.d.lk.Unlock()
+	if err == nil && w.d.unavailable {
+		return nil, errors.New("registry unavailable")
+	}
+	return ins, err
+}
+
+func instance(id string) *registry.ServiceInstance {
+	return &registry.ServiceInstance{
+		ID:        id,
+		Name:      "helloworld",
+		Endpoints: []string{"grpc://127.0.0.1:9000"},
+	}
+}
+
+func next(t *testing.T, w registry.Watcher) []*registry.ServiceInstance {
+	t.Helper()
+	ch := make(chan []*registry.ServiceInstance, 1)
+	go func() {
+		ins, err := w.Next()
+		assert.Nil(t, err)
+		ch <- ins
+	}()
+	select {
+	case ins := <-ch:
+		return ins
+	case <-time.After(time.Second):
+		t.Fatal("Next() blocked")
+		return nil
+	}
+}
+
+func TestDiscovery_SharedWatch(t *testing.T) {
+	ctx := context.Background()
+	upstream := &countingDiscovery{Registry: memory.New()}
+	assert.Nil(t, upstream.Register(ctx, instance("1")))
+	d := New(upstream)
+
+	w1, err := d.Watch(ctx, "helloworld")
+	assert.Nil(t, err)
+	w2, err := d.Watch(ctx, "helloworld")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, upstream.watches)
+
+	assert.Len(t, next(t, w1), 1)
+	assert.Len(t, next(t, w2), 1)
+
+	assert.Nil(t, upstream.Register(ctx, instance("2")))
+	assert.Len(t, next(t, w1), 2)
+	assert.Len(t, next(t, w2), 2)
+
+	// a late watcher gets the cached snapshot
+	w3, err := d.Watch(ctx, "helloworld")
+	assert.Nil(t, err)
+	assert.Len(t, next(t, w3), 2)
+	assert.Equal(t, 1, upstream.watches)
+
+	// GetService is served from the cache while watching
+	ins, err := d.GetService(ctx, "helloworld")
+	assert.Nil(t, err)
+	assert.Len(t, ins, 2)
+	assert.Equal(t, 0, upstream.gets)
+
+	assert.Nil(t, w1.Stop())
+	assert.Nil(t, w2.Stop())
+	assert.Nil(t, w3.Stop())
+	assert.Nil(t, w3.Stop())
+
+	// the upstream watch is stopped with the last watcher
+	_, err = w1.Next()
+	assert.True(t, errors.Is(err, context.Canceled))
+	w4, err := d.Watch(ctx, "helloworld")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, upstream.watches)
+	assert.Nil(t, w4.Stop())
+}
+
+func TestDiscovery_SlowUpstreamWatch(t *testing.T) {
+	ctx := context.Background()
+	upstream := &countingDiscovery{Registry: memory.New(), block: make(chan struct{})}
+	assert.Nil(t, upstream.Register(ctx, instance("1")))
+	d := New(upstream)
+
+	type result struct {
+		w   registry.Watcher
+		err error
+	}
+	results := make(chan result, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			w, err := d.Watch(ctx, "helloworld")
+			results <- result{w, err}
+		}()
+	}
+
+	// the cache is not locked while the upstream watch is being created
+	got := make(chan error, 1)
+	go func() {
+		_, err := d.GetService(ctx, "other")
+		got <- err
+	}()
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("GetService() blocked by a pending upstream watch")
+	}
+
+	close(upstream.block)
+	for i := 0; i < 2; i++ {
+		r := <-results
+		assert.Nil(t, r.err)
+		assert.Len(t, next(t, r.w), 1)
+		assert.Nil(t, r.w.Stop())
+	}
+	assert.Equal(t, 1, upstream.watches)
+}
+
+func TestDiscovery_LastKnownGood(t *testing.T) {
+	ctx := context.Background()
+	upstream := &countingDiscovery{Registry: memory.New()}
+	assert.Nil(t, upstream.Register(ctx, instance("1")))
+	d := New(upstream)
+
+	ins, err := d.GetService(ctx, "helloworld")
+	assert.Nil(t, err)
+	assert.Len(t, ins, 1)
+
+	w, err := d.Watch(ctx, "helloworld")
+	assert.Nil(t, err)
+	defer w.Stop()
+	assert.Len(t, next(t, w), 1)
+
+	// the upstream fails, the watcher keeps the last known good instances
+	upstream.setUnavailable(true)
+	assert.Nil(t, upstream.Register(ctx, instance("2")))
+	time.Sleep(100 * time.Millisecond)
+	ins, err = d.GetService(ctx, "helloworld")
+	assert.Nil(t, err)
+	assert.Len(t, ins, 1)
+
+	// without a watch the cache is the fallback of GetService
+	assert.Nil(t, w.Stop())
+	ins, err = d.GetService(ctx, "helloworld")
+	assert.Nil(t, err)
+	assert.Len(t, ins, 1)
+
+	_, err = d.GetService(ctx, "notfound")
+	assert.NotNil(t, err)
+}
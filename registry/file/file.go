@@ -0,0 +1,258 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apus-run/gaea/registry"
+)
+
+var (
+	_ registry.Registry  = (*Registry)(nil)
+	_ registry.Discovery = (*Registry)(nil)
+)
+
+const (
+	lockFile = ".lock"
+	ext      = ".json"
+)
+
+// ErrInvalidInstance is returned when registering an instance without id or name.
+var ErrInvalidInstance = errors.New("file: service instance must have id and name")
+
+// Option is file registry option.
+type Option func(r *Registry)
+
+// WithTTL with the ttl of instances, an instance whose file is not
+// refreshed by Register within the ttl is stale. Zero disables it.
+func WithTTL(ttl time.Duration) Option {
+	return func(r *Registry) {
+		r.ttl = ttl
+	}
+}
+
+// WithPollInterval with the interval watchers poll the directory.
+func WithPollInterval(interval time.Duration) Option {
+	return func(r *Registry) {
+		r.pollInterval = interval
+	}
+}
+
+// Registry is a registry.Registry and registry.Discovery backed by a shared
+// directory, one JSON file per service instance:
+//
+//	<dir>/<service name>/<instance id>.json
+//
+// It is meant for running several services on one host during local
+// development. Instances whose process is gone or whose ttl expired are
+// cleaned up when they are read.
+type Registry struct {
+	dir          string
+	ttl          time.Duration
+	pollInterval time.Duration
+}
+
+// record is the content of an instance file.
+type record struct {
+	*registry.ServiceInstance
+	// PID is the process id of the registered instance, used to check liveness.
+	PID int `json:"pid"`
+}
+
+// New creates a file registry in dir.
+func New(dir string, opts ...Option) (*Registry, error) {
+	r := &Registry{
+		dir:          dir,
+		pollInterval: time.Second,
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Register writes the instance file, registering again refreshes its ttl.
+func (r *Registry) Register(_ context.Context, svc *registry.ServiceInstance) error {
+	if svc == nil || svc.ID == "" || svc.Name == "" {
+		return ErrInvalidInstance
+	}
+	b, err := json.Marshal(record{ServiceInstance: svc, PID: os.Getpid()})
+	if err != nil {
+		return err
+	}
+	return r.withLock(func() error {
+		dir := r.serviceDir(svc.Name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		// write to a temp file then rename it, so readers never see a partial file
+		tmp, err := os.CreateTemp(dir, ".tmp-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(b); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), r.instanceFile(svc.Name, svc.ID))
+	})
+}
+
+// Deregister removes the instance file.
+func (r *Registry) Deregister(_ context.Context, svc *registry.ServiceInstance) error {
+	if svc == nil || svc.ID == "" || svc.Name == "" {
+		return ErrInvalidInstance
+	}
+	return r.withLock(func() error {
+		err := os.Remove(r.instanceFile(svc.Name, svc.ID))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	})
+}
+
+// GetService returns the live instances of the service, ordered by id.
+func (r *Registry) GetService(_ context.Context, serviceName string) ([]*registry.ServiceInstance, error) {
+	return r.list(serviceName)
+}
+
+// GetServiceList returns the live instances of all the services, ordered by name and id.
+func (r *Registry) GetServiceList(_ context.Context) ([]*registry.ServiceInstance, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+	var all []*registry.ServiceInstance
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		name, err := url.PathUnescape(e.Name())
+		if err != nil {
+			continue
+		}
+		ins, err := r.list(name)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, ins...)
+	}
+	return all, nil
+}
+
+// Watch creates a watcher which polls the service directory.
+func (r *Registry) Watch(ctx context.Context, serviceName string) (registry.Watcher, error) {
+	return newWatcher(ctx, r, serviceName), nil
+}
+
+func (r *Registry) list(serviceName string) ([]*registry.ServiceInstance, error) {
+	dir := r.serviceDir(serviceName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ins := make([]*registry.ServiceInstance, 0, len(entries))
+	var stale []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ext) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		rec, modTime, err := readRecord(path)
+		if err != nil {
+			// removed concurrently or not an instance file
+			continue
+		}
+		if r.isStale(rec, modTime) {
+			stale = append(stale, path)
+			continue
+		}
+		ins = append(ins, rec.ServiceInstance)
+	}
+	if len(stale) > 0 {
+		r.cleanup(stale)
+	}
+	sort.Slice(ins, func(i, j int) bool {
+		return ins[i].ID < ins[j].ID
+	})
+	return ins, nil
+}
+
+func (r *Registry) isStale(rec *record, modTime time.Time) bool {
+	if r.ttl > 0 && time.Since(modTime) > r.ttl {
+		return true
+	}
+	return rec.PID > 0 && !processAlive(rec.PID)
+}
+
+// cleanup removes the stale instance files, the files are checked again
+// under the lock because they may have been refreshed meanwhile.
+func (r *Registry) cleanup(paths []string) {
+	_ = r.withLock(func() error {
+		for _, path := range paths {
+			rec, modTime, err := readRecord(path)
+			if err != nil || !r.isStale(rec, modTime) {
+				continue
+			}
+			_ = os.Remove(path)
+		}
+		return nil
+	})
+}
+
+func (r *Registry) withLock(fn func() error) error {
+	f, err := os.OpenFile(filepath.Join(r.dir, lockFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lock(f); err != nil {
+		return err
+	}
+	defer func() {
+		_ = unlock(f)
+	}()
+	return fn()
+}
+
+func (r *Registry) serviceDir(serviceName string) string {
+	return filepath.Join(r.dir, url.PathEscape(serviceName))
+}
+
+func (r *Registry) instanceFile(serviceName, id string) string {
+	return filepath.Join(r.serviceDir(serviceName), url.PathEscape(id)+ext)
+}
+
+func readRecord(path string) (*record, time.Time, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	rec := &record{ServiceInstance: &registry.ServiceInstance{}}
+	if err := json.Unmarshal(b, rec); err != nil {
+		return nil, time.Time{}, err
+	}
+	return rec, fi.ModTime(), nil
+}
@@ -0,0 +1,123 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apus-run/gaea/registry"
+)
+
+func instance(id, name string) *registry.ServiceInstance {
+	return &registry.ServiceInstance{
+		ID:        id,
+		Name:      name,
+		Version:   "v1.0.0",
+		Metadata:  map[string]string{"zone": "a"},
+		Endpoints: []string{"grpc://127.0.0.1:9000"},
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	r, err := New(t.TempDir())
+	assert.Nil(t, err)
+
+	assert.ErrorIs(t, r.Register(ctx, &registry.ServiceInstance{}), ErrInvalidInstance)
+
+	assert.Nil(t, r.Register(ctx, instance("2", "helloworld")))
+	assert.Nil(t, r.Register(ctx, instance("1/a", "helloworld")))
+	assert.Nil(t, r.Register(ctx, instance("3", "greeter")))
+
+	ins, err := r.GetService(ctx, "helloworld")
+	assert.Nil(t, err)
+	assert.Equal(t, []*registry.ServiceInstance{instance("1/a", "helloworld"), instance("2", "helloworld")}, ins)
+
+	all, err := r.GetServiceList(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, all, 3)
+
+	assert.Nil(t, r.Deregister(ctx, instance("2", "helloworld")))
+	assert.Nil(t, r.Deregister(ctx, instance("2", "helloworld")))
+	ins, _ = r.GetService(ctx, "helloworld")
+	assert.Len(t, ins, 1)
+
+	ins, err = r.GetService(ctx, "notfound")
+	assert.Nil(t, err)
+	assert.Len(t, ins, 0)
+}
+
+func TestRegistry_TTL(t *testing.T) {
+	ctx := context.Background()
+	r, err := New(t.TempDir(), WithTTL(time.Minute))
+	assert.Nil(t, err)
+	svc := instance("1", "helloworld")
+	assert.Nil(t, r.Register(ctx, svc))
+
+	old := time.Now().Add(-2 * time.Minute)
+	assert.Nil(t, os.Chtimes(r.instanceFile(svc.Name, svc.ID), old, old))
+
+	ins, err := r.GetService(ctx, "helloworld")
+	assert.Nil(t, err)
+	assert.Len(t, ins, 0)
+	_, err = os.Stat(r.instanceFile(svc.Name, svc.ID))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestRegistry_DeadProcess(t *testing.T) {
+	ctx := context.Background()
+	r, err := New(t.TempDir())
+	assert.Nil(t, err)
+	svc := instance("1", "helloworld")
+	assert.Nil(t, r.Register(ctx, svc))
+
+	// rewrite the instance as if it was registered by a process which is gone
+	b, err := json.Marshal(record{ServiceInstance: svc, PID: 1<<31 - 1})
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(r.instanceFile(svc.Name, svc.ID), b, 0o644))
+
+	ins, err := r.GetService(ctx, "helloworld")
+	assert.Nil(t, err)
+	assert.Len(t, ins, 0)
+}
+
+func TestWatcher(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	r, err := New(dir, WithPollInterval(10*time.Millisecond))
+	assert.Nil(t, err)
+	assert.Nil(t, r.Register(ctx, instance("1", "helloworld")))
+
+	w, err := r.Watch(ctx, "helloworld")
+	assert.Nil(t, err)
+
+	// initial snapshot
+	ins, err := w.Next()
+	assert.Nil(t, err)
+	assert.Len(t, ins, 1)
+
+	// another process registers in the same directory
+	other, err := New(dir)
+	assert.Nil(t, err)
+	time.AfterFunc(50*time.Millisecond, func() {
+		_ = other.Register(ctx, instance("2", "helloworld"))
+	})
+	ins, err = w.Next()
+	assert.Nil(t, err)
+	assert.Len(t, ins, 2)
+
+	assert.Nil(t, w.Stop())
+	_, err = w.Next()
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	// no partial or temp files left behind
+	entries, err := os.ReadDir(filepath.Join(dir, "helloworld"))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+}
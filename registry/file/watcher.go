@@ -0,0 +1,70 @@
+package file
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/apus-run/gaea/registry"
+)
+
+var _ registry.Watcher = (*watcher)(nil)
+
+type watcher struct {
+	r           *Registry
+	serviceName string
+	ctx         context.Context
+	cancel      context.CancelFunc
+
+	first bool
+	last  []*registry.ServiceInstance
+}
+
+func newWatcher(ctx context.Context, r *Registry, serviceName string) *watcher {
+	ctx, cancel := context.WithCancel(ctx)
+	return &watcher{
+		r:           r,
+		serviceName: serviceName,
+		ctx:         ctx,
+		cancel:      cancel,
+		first:       true,
+	}
+}
+
+// Next implements registry.Watcher.
+func (w *watcher) Next() ([]*registry.ServiceInstance, error) {
+	ticker := time.NewTicker(w.r.pollInterval)
+	defer ticker.Stop()
+	for {
+		ins, err := w.r.list(w.serviceName)
+		if err != nil {
+			return nil, err
+		}
+		if w.changed(ins) {
+			w.first = false
+			w.last = ins
+			return ins, nil
+		}
+		select {
+		case <-w.ctx.Done():
+			return nil, w.ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *watcher) changed(ins []*registry.ServiceInstance) bool {
+	if w.first {
+		return len(ins) > 0
+	}
+	if len(ins) == 0 && len(w.last) == 0 {
+		return false
+	}
+	return !reflect.DeepEqual(ins, w.last)
+}
+
+// Stop implements registry.Watcher.
+func (w *watcher) Stop() error {
+	w.cancel()
+	return nil
+}
@@ -0,0 +1,198 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/apus-run/gaea/registry"
+)
+
+var (
+	_ registry.Registry  = (*Registry)(nil)
+	_ registry.Discovery = (*Registry)(nil)
+)
+
+// ErrInvalidInstance is returned when registering an instance without id or name.
+var ErrInvalidInstance = errors.New("memory: service instance must have id and name")
+
+// Registry is an in-process implementation of registry.Registry and
+// registry.Discovery. It is useful for tests, and for wiring several
+// applications inside one process.
+type Registry struct {
+	mu       sync.RWMutex
+	services map[string]map[string]*registry.ServiceInstance
+	watchers map[string]map[*watcher]struct{}
+}
+
+// New creates an in-memory registry.
+func New() *Registry {
+	return &Registry{
+		services: make(map[string]map[string]*registry.ServiceInstance),
+		watchers: make(map[string]map[*watcher]struct{}),
+	}
+}
+
+// Register registers the service instance, registering an instance with
+// the same id again replaces it. Watchers are only notified of changes.
+func (r *Registry) Register(_ context.Context, svc *registry.ServiceInstance) error {
+	if svc == nil || svc.ID == "" || svc.Name == "" {
+		return ErrInvalidInstance
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ins, ok := r.services[svc.Name]
+	if !ok {
+		ins = make(map[string]*registry.ServiceInstance)
+		r.services[svc.Name] = ins
+	}
+	if old, ok := ins[svc.ID]; ok && reflect.DeepEqual(old, svc) {
+		return nil
+	}
+	ins[svc.ID] = clone(svc)
+	r.notify(svc.Name)
+	return nil
+}
+
+// Deregister deregisters the service instance.
+func (r *Registry) Deregister(_ context.Context, svc *registry.ServiceInstance) error {
+	if svc == nil || svc.ID == "" || svc.Name == "" {
+		return ErrInvalidInstance
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ins, ok := r.services[svc.Name]
+	if !ok {
+		return nil
+	}
+	if _, ok := ins[svc.ID]; !ok {
+		return nil
+	}
+	delete(ins, svc.ID)
+	if len(ins) == 0 {
+		delete(r.services, svc.Name)
+	}
+	r.notify(svc.Name)
+	return nil
+}
+
+// GetService returns the instances of the service, ordered by id.
+func (r *Registry) GetService(_ context.Context, serviceName string) ([]*registry.ServiceInstance, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.list(serviceName), nil
+}
+
+// GetServiceList returns the instances of all the services, ordered by name and id.
+func (r *Registry) GetServiceList(_ context.Context) ([]*registry.ServiceInstance, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.services))
+	for name := range r.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var all []*registry.ServiceInstance
+	for _, name := range names {
+		all = append(all, r.list(name)...)
+	}
+	return all, nil
+}
+
+// Watch creates a watcher of the service. The first Next returns the
+// current instances if there are any.
+func (r *Registry) Watch(ctx context.Context, serviceName string) (registry.Watcher, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &watcher{
+		r:           r,
+		serviceName: serviceName,
+		ctx:         ctx,
+		cancel:      cancel,
+		event:       make(chan struct{}, 1),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ws, ok := r.watchers[serviceName]
+	if !ok {
+		ws = make(map[*watcher]struct{})
+		r.watchers[serviceName] = ws
+	}
+	ws[w] = struct{}{}
+	if len(r.services[serviceName]) > 0 {
+		w.event <- struct{}{}
+	}
+	return w, nil
+}
+
+// list must be called with r.mu held.
+func (r *Registry) list(serviceName string) []*registry.ServiceInstance {
+	ins := r.services[serviceName]
+	list := make([]*registry.ServiceInstance, 0, len(ins))
+	for _, in := range ins {
+		list = append(list, in)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// notify must be called with r.mu held.
+func (r *Registry) notify(serviceName string) {
+	for w := range r.watchers[serviceName] {
+		// pending events are coalesced, Next always reads the latest instances
+		select {
+		case w.event <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *Registry) removeWatcher(w *watcher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ws := r.watchers[w.serviceName]
+	delete(ws, w)
+	if len(ws) == 0 {
+		delete(r.watchers, w.serviceName)
+	}
+}
+
+type watcher struct {
+	r           *Registry
+	serviceName string
+	ctx         context.Context
+	cancel      context.CancelFunc
+	event       chan struct{}
+}
+
+// Next implements registry.Watcher.
+func (w *watcher) Next() ([]*registry.ServiceInstance, error) {
+	select {
+	case <-w.ctx.Done():
+		return nil, w.ctx.Err()
+	case <-w.event:
+	}
+	return w.r.GetService(w.ctx, w.serviceName)
+}
+
+// Stop implements registry.Watcher.
+func (w *watcher) Stop() error {
+	w.cancel()
+	w.r.removeWatcher(w)
+	return nil
+}
+
+func clone(svc *registry.ServiceInstance) *registry.ServiceInstance {
+	c := *svc
+	if svc.Metadata != nil {
+		c.Metadata = make(map[string]string, len(svc.Metadata))
+		for k, v := range svc.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+	c.Endpoints = append([]string(nil), svc.Endpoints...)
+	return &c
+}
@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apus-run/gaea/registry"
+)
+
+func instance(id, name string) *registry.ServiceInstance {
+	return &registry.ServiceInstance{
+		ID:        id,
+		Name:      name,
+		Version:   "v1.0.0",
+		Metadata:  map[string]string{"zone": "a"},
+		Endpoints: []string{"grpc://127.0.0.1:9000"},
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	r := New()
+
+	assert.ErrorIs(t, r.Register(ctx, &registry.ServiceInstance{}), ErrInvalidInstance)
+
+	svc := instance("2", "helloworld")
+	assert.Nil(t, r.Register(ctx, svc))
+	assert.Nil(t, r.Register(ctx, instance("1", "helloworld")))
+	assert.Nil(t, r.Register(ctx, instance("3", "greeter")))
+
+	// the registered instance is a copy
+	svc.Metadata["zone"] = "b"
+
+	ins, err := r.GetService(ctx, "helloworld")
+	assert.Nil(t, err)
+	assert.Len(t, ins, 2)
+	assert.Equal(t, "1", ins[0].ID)
+	assert.Equal(t, "a", ins[1].Metadata["zone"])
+
+	all, err := r.GetServiceList(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, all, 3)
+	assert.Equal(t, "greeter", all[0].Name)
+
+	assert.Nil(t, r.Deregister(ctx, svc))
+	assert.Nil(t, r.Deregister(ctx, svc))
+	ins, _ = r.GetService(ctx, "helloworld")
+	assert.Len(t, ins, 1)
+}
+
+func next(t *testing.T, w registry.Watcher) []*registry.ServiceInstance {
+	t.Helper()
+	type result struct {
+		ins []*registry.ServiceInstance
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		ins, err := w.Next()
+		ch <- result{ins, err}
+	}()
+	select {
+	case res := <-ch:
+		assert.Nil(t, res.err)
+		return res.ins
+	case <-time.After(time.Second):
+		t.Fatal("Next() blocked")
+		return nil
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	ctx := context.Background()
+	r := New()
+	assert.Nil(t, r.Register(ctx, instance("1", "helloworld")))
+
+	w, err := r.Watch(ctx, "helloworld")
+	assert.Nil(t, err)
+
+	// initial snapshot
+	assert.Len(t, next(t, w), 1)
+
+	// change notification
+	assert.Nil(t, r.Register(ctx, instance("2", "helloworld")))
+	assert.Len(t, next(t, w), 2)
+
+	// registering the same instance again is not a change
+	assert.Nil(t, r.Register(ctx, instance("2", "helloworld")))
+	assert.Len(t, r.watchers["helloworld"], 1)
+	for w := range r.watchers["helloworld"] {
+		assert.Len(t, w.event, 0)
+	}
+
+	// changes of other services are not notified
+	assert.Nil(t, r.Register(ctx, instance("3", "greeter")))
+	assert.Nil(t, r.Deregister(ctx, instance("1", "helloworld")))
+	assert.Nil(t, r.Deregister(ctx, instance("2", "helloworld")))
+	assert.Len(t, next(t, w), 0)
+
+	// stop
+	assert.Nil(t, w.Stop())
+	_, err = w.Next()
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Len(t, r.watchers, 0)
+}
+
+func TestWatcher_Empty(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r := New()
+	w, err := r.Watch(ctx, "helloworld")
+	assert.Nil(t, err)
+
+	// blocks until the context is done when there is no instance
+	_, err = w.Next()
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
@@ -0,0 +1,172 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// FailurePolicy decides whether a failure of one backend fails the
+// registration of a multi registry.
+type FailurePolicy int
+
+const (
+	// FailOnAny fails when any of the backends fails.
+	FailOnAny FailurePolicy = iota
+	// FailOnAll fails only when all of the backends fail, partial failures
+	// are only reported to the error handler.
+	FailOnAll
+)
+
+// MultiOption is multi registry option.
+type MultiOption func(m *multiRegistry)
+
+// WithFailurePolicy with the failure policy, default is FailOnAny.
+func WithFailurePolicy(p FailurePolicy) MultiOption {
+	return func(m *multiRegistry) {
+		m.policy = p
+	}
+}
+
+// WithErrorHandler with the handler of every backend failure.
+func WithErrorHandler(h func(r Registry, err error)) MultiOption {
+	return func(m *multiRegistry) {
+		m.errorHandler = h
+	}
+}
+
+type multiRegistry struct {
+	registries   []Registry
+	policy       FailurePolicy
+	errorHandler func(r Registry, err error)
+
+	mu sync.Mutex
+	// registered records by instance ID the backends holding the instance
+	registered map[string][]bool
+}
+
+// NewMultiRegistry returns a Registry which registers and deregisters the
+// service instance in all the registries in parallel.
+// It is useful during a registry migration.
+func NewMultiRegistry(rs []Registry, opts ...MultiOption) Registry {
+	m := &multiRegistry{
+		registries: rs,
+		policy:     FailOnAny,
+		registered: make(map[string][]bool),
+	}
+	for _, o := range opts {
+		o(m)
+	}
+	return m
+}
+
+// Register implements Registry.
+// When the registration fails, the backends which newly registered the
+// instance are deregistered again, so that the first registration does not
+// leave the instance half registered. The backends which already held the
+// instance, such as on a heartbeat or a metadata update, keep it.
+func (m *multiRegistry) Register(ctx context.Context, svc *ServiceInstance) error {
+	errs := m.do(m.registries, func(r Registry) error {
+		return r.Register(ctx, svc)
+	})
+
+	m.mu.Lock()
+	held := m.registered[svc.ID]
+	if held == nil {
+		held = make([]bool, len(m.registries))
+		m.registered[svc.ID] = held
+	}
+	var (
+		rollback []Registry
+		indexes  []int
+	)
+	for i, e := range errs {
+		if e != nil {
+			continue
+		}
+		if !held[i] {
+			rollback = append(rollback, m.registries[i])
+			indexes = append(indexes, i)
+		}
+		held[i] = true
+	}
+	m.mu.Unlock()
+
+	err := m.result(errs)
+	if err == nil {
+		return nil
+	}
+	for i, e := range m.do(rollback, func(r Registry) error {
+		return r.Deregister(ctx, svc)
+	}) {
+		if e != nil {
+			err = errors.Join(err, fmt.Errorf("rollback registry[%d]: %w", indexes[i], e))
+			if m.errorHandler != nil {
+				m.errorHandler(rollback[i], e)
+			}
+			continue
+		}
+		m.mu.Lock()
+		m.registered[svc.ID][indexes[i]] = false
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// Deregister implements Registry.
+func (m *multiRegistry) Deregister(ctx context.Context, svc *ServiceInstance) error {
+	errs := m.do(m.registries, func(r Registry) error {
+		return r.Deregister(ctx, svc)
+	})
+	m.mu.Lock()
+	if held := m.registered[svc.ID]; held != nil {
+		remaining := false
+		for i, e := range errs {
+			if e == nil {
+				held[i] = false
+			}
+			remaining = remaining || held[i]
+		}
+		if !remaining {
+			delete(m.registered, svc.ID)
+		}
+	}
+	m.mu.Unlock()
+	return m.result(errs)
+}
+
+// do calls fn on every registry in parallel and returns the error of each.
+func (m *multiRegistry) do(rs []Registry, fn func(r Registry) error) []error {
+	errs := make([]error, len(rs))
+	var wg sync.WaitGroup
+	for i, r := range rs {
+		wg.Add(1)
+		go func(i int, r Registry) {
+			defer wg.Done()
+			errs[i] = fn(r)
+		}(i, r)
+	}
+	wg.Wait()
+	return errs
+}
+
+// result reports the errors to the error handler and applies the failure
+// policy to them.
+func (m *multiRegistry) result(errs []error) error {
+	failed := 0
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		failed++
+		errs[i] = fmt.Errorf("registry[%d]: %w", i, err)
+		if m.errorHandler != nil {
+			m.errorHandler(m.registries[i], err)
+		}
+	}
+	if failed == 0 || (m.policy == FailOnAll && failed < len(m.registries)) {
+		return nil
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockRegistry struct {
+	lk         sync.Mutex
+	err        error
+	calls      int
+	registered bool
+}
+
+func (r *mockRegistry) Register(ctx context.Context, svc *ServiceInstance) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.calls++
+	if r.err == nil {
+		r.registered = true
+	}
+	return r.err
+}
+
+func (r *mockRegistry) Deregister(ctx context.Context, svc *ServiceInstance) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.calls++
+	if r.err == nil {
+		r.registered = false
+	}
+	return r.err
+}
+
+func TestMultiRegistry(t *testing.T) {
+	r1, r2 := &mockRegistry{}, &mockRegistry{}
+	m := NewMultiRegistry([]Registry{r1, r2})
+
+	assert.Nil(t, m.Register(context.Background(), &ServiceInstance{}))
+	assert.Nil(t, m.Deregister(context.Background(), &ServiceInstance{}))
+	assert.Equal(t, 2, r1.calls)
+	assert.Equal(t, 2, r2.calls)
+}
+
+func TestMultiRegistry_FailOnAny(t *testing.T) {
+	errBackend := errors.New("backend down")
+	var reported []Registry
+	r1, r2 := &mockRegistry{}, &mockRegistry{err: errBackend}
+	m := NewMultiRegistry([]Registry{r1, r2}, WithErrorHandler(func(r Registry, err error) {
+		reported = append(reported, r)
+	}))
+
+	err := m.Register(context.Background(), &ServiceInstance{})
+	assert.ErrorIs(t, err, errBackend)
+	assert.Contains(t, err.Error(), "registry[1]")
+	assert.Equal(t, []Registry{r2}, reported)
+	// r1 succeeded and is rolled back
+	assert.Equal(t, 2, r1.calls)
+	assert.False(t, r1.registered)
+}
+
+func TestMultiRegistry_FailOnAll(t *testing.T) {
+	errBackend := errors.New("backend down")
+	var reported int
+	r1, r2 := &mockRegistry{}, &mockRegistry{err: errBackend}
+	m := NewMultiRegistry([]Registry{r1, r2},
+		WithFailurePolicy(FailOnAll),
+		WithErrorHandler(func(r Registry, err error) {
+			reported++
+		}),
+	)
+
+	assert.Nil(t, m.Register(context.Background(), &ServiceInstance{}))
+	assert.Equal(t, 1, reported)
+	assert.True(t, r1.registered)
+
+	r1.err = errBackend
+	assert.ErrorIs(t, m.Register(context.Background(), &ServiceInstance{}), errBackend)
+	assert.Equal(t, 3, reported)
+}
+
+func TestMultiRegistry_Reregister(t *testing.T) {
+	errBackend := errors.New("backend down")
+	r1, r2 := &mockRegistry{}, &mockRegistry{}
+	m := NewMultiRegistry([]Registry{r1, r2})
+	svc := &ServiceInstance{ID: "1"}
+	assert.Nil(t, m.Register(context.Background(), svc))
+
+	// a backend blipping on re-registration does not deregister the
+	// instance from the healthy backend
+	r2.err = errBackend
+	assert.ErrorIs(t, m.Register(context.Background(), svc), errBackend)
+	assert.True(t, r1.registered)
+	assert.Equal(t, 2, r1.calls)
+
+	// once deregistered, a failed registration is rolled back again
+	r2.err = nil
+	assert.Nil(t, m.Deregister(context.Background(), svc))
+	r2.err = errBackend
+	assert.ErrorIs(t, m.Register(context.Background(), svc), errBackend)
+	assert.False(t, r1.registered)
+}
@@ -0,0 +1,327 @@
+package multicast
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	log "google.golang.org/grpc/grpclog"
+
+	"github.com/apus-run/gaea/registry"
+	"github.com/apus-run/gaea/registry/memory"
+)
+
+var (
+	_ registry.Registry  = (*Registry)(nil)
+	_ registry.Discovery = (*Registry)(nil)
+)
+
+const (
+	// DefaultGroup is the default multicast group of announcements.
+	DefaultGroup = "239.255.77.77:7777"
+
+	// maxMessageSize is the max payload of an UDP datagram.
+	maxMessageSize = 65507
+
+	typeAnnounce = "announce"
+	typeBye      = "bye"
+)
+
+// ErrMessageTooLarge is returned when an instance does not fit into one datagram.
+var ErrMessageTooLarge = errors.New("multicast: service instance is too large to announce")
+
+// Option is multicast registry option.
+type Option func(r *Registry)
+
+// WithGroup with the multicast group address, default is DefaultGroup.
+func WithGroup(addr string) Option {
+	return func(r *Registry) {
+		r.groupAddr = addr
+	}
+}
+
+// WithInterface with the network interface to announce and listen on,
+// default is chosen by the system.
+func WithInterface(ifi *net.Interface) Option {
+	return func(r *Registry) {
+		r.ifi = ifi
+	}
+}
+
+// WithAnnounceInterval with the interval of announcing the registered instances.
+func WithAnnounceInterval(interval time.Duration) Option {
+	return func(r *Registry) {
+		r.interval = interval
+	}
+}
+
+// WithTTL with the ttl of announced instances, peers which stop announcing
+// expire after it. Default is three announce intervals.
+func WithTTL(ttl time.Duration) Option {
+	return func(r *Registry) {
+		r.ttl = ttl
+	}
+}
+
+// message is the announcement on the wire.
+type message struct {
+	Type string `json:"type"`
+	// TTL in milliseconds, after which the instance expires unless announced again.
+	TTL      int64                     `json:"ttl"`
+	Instance *registry.ServiceInstance `json:"instance"`
+}
+
+// Registry is a zero-config registry.Registry and registry.Discovery for
+// networks without a central registry. Registered instances are announced
+// over UDP multicast, and the announcements of peers build a watchable view
+// which expires the peers that stop announcing.
+type Registry struct {
+	groupAddr string
+	ifi       *net.Interface
+	interval  time.Duration
+	ttl       time.Duration
+
+	group *net.UDPAddr
+	recv  *net.UDPConn
+	send  *net.UDPConn
+
+	view *memory.Registry
+
+	mu    sync.Mutex
+	local map[string]*registry.ServiceInstance
+	seen  map[instanceKey]time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type instanceKey struct {
+	name string
+	id   string
+}
+
+// New creates a multicast registry and starts listening to announcements.
+// Close must be called to stop it.
+func New(opts ...Option) (*Registry, error) {
+	r := &Registry{
+		groupAddr: DefaultGroup,
+		interval:  time.Second,
+		view:      memory.New(),
+		local:     make(map[string]*registry.ServiceInstance),
+		seen:      make(map[instanceKey]time.Time),
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	if r.ttl <= 0 {
+		r.ttl = 3 * r.interval
+	}
+
+	group, err := net.ResolveUDPAddr("udp4", r.groupAddr)
+	if err != nil {
+		return nil, err
+	}
+	if !group.IP.IsMulticast() {
+		return nil, fmt.Errorf("multicast: %s is not a multicast address", r.groupAddr)
+	}
+	r.group = group
+	r.recv, err = net.ListenMulticastUDP("udp4", r.ifi, group)
+	if err != nil {
+		return nil, err
+	}
+	r.send, err = net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		_ = r.recv.Close()
+		return nil, err
+	}
+	pc := ipv4.NewPacketConn(r.send)
+	if r.ifi != nil {
+		if err = pc.SetMulticastInterface(r.ifi); err != nil {
+			r.closeConns()
+			return nil, err
+		}
+	}
+	// peers on the same host, including this one, receive the announcements
+	if err = pc.SetMulticastLoopback(true); err != nil {
+		r.closeConns()
+		return nil, err
+	}
+
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.wg.Add(2)
+	go r.receive()
+	go r.announce()
+	return r, nil
+}
+
+// Register announces the service instance until it is deregistered.
+func (r *Registry) Register(ctx context.Context, svc *registry.ServiceInstance) error {
+	if svc == nil || svc.ID == "" || svc.Name == "" {
+		return memory.ErrInvalidInstance
+	}
+	// publishing under the lock keeps the announcements and byes in order
+	r.mu.Lock()
+	if err := r.publish(typeAnnounce, svc); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	r.local[svc.ID] = svc
+	r.mu.Unlock()
+	r.apply(&message{Type: typeAnnounce, TTL: r.ttl.Milliseconds(), Instance: svc})
+	return nil
+}
+
+// Deregister stops announcing the service instance and tells the peers it is gone.
+func (r *Registry) Deregister(ctx context.Context, svc *registry.ServiceInstance) error {
+	if svc == nil || svc.ID == "" || svc.Name == "" {
+		return memory.ErrInvalidInstance
+	}
+	r.mu.Lock()
+	delete(r.local, svc.ID)
+	err := r.publish(typeBye, svc)
+	r.mu.Unlock()
+	r.apply(&message{Type: typeBye, Instance: svc})
+	return err
+}
+
+// GetService returns the instances of the service seen on the network.
+func (r *Registry) GetService(ctx context.Context, serviceName string) ([]*registry.ServiceInstance, error) {
+	return r.view.GetService(ctx, serviceName)
+}
+
+// GetServiceList returns the instances of all the services seen on the network.
+func (r *Registry) GetServiceList(ctx context.Context) ([]*registry.ServiceInstance, error) {
+	return r.view.GetServiceList(ctx)
+}
+
+// Watch creates a watcher of the service seen on the network.
+func (r *Registry) Watch(ctx context.Context, serviceName string) (registry.Watcher, error) {
+	return r.view.Watch(ctx, serviceName)
+}
+
+// Close says bye for the registered instances and stops the registry.
+func (r *Registry) Close() error {
+	var errs []error
+	r.mu.Lock()
+	for id, svc := range r.local {
+		if err := r.publish(typeBye, svc); err != nil {
+			errs = append(errs, err)
+		}
+		delete(r.local, id)
+	}
+	r.mu.Unlock()
+	r.cancel()
+	r.closeConns()
+	r.wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (r *Registry) closeConns() {
+	if r.recv != nil {
+		_ = r.recv.Close()
+	}
+	if r.send != nil {
+		_ = r.send.Close()
+	}
+}
+
+func (r *Registry) publish(typ string, svc *registry.ServiceInstance) error {
+	b, err := json.Marshal(&message{Type: typ, TTL: r.ttl.Milliseconds(), Instance: svc})
+	if err != nil {
+		return err
+	}
+	if len(b) > maxMessageSize {
+		return ErrMessageTooLarge
+	}
+	_, err = r.send.WriteToUDP(b, r.group)
+	return err
+}
+
+func (r *Registry) receive() {
+	defer r.wg.Done()
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, _, err := r.recv.ReadFromUDP(buf)
+		if err != nil {
+			if r.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Errorf("[multicast] failed to read announcement: %v", err)
+			continue
+		}
+		msg := &message{}
+		if err := json.Unmarshal(buf[:n], msg); err != nil {
+			log.Warningf("[multicast] invalid announcement: %v", err)
+			continue
+		}
+		if msg.Instance == nil || msg.Instance.ID == "" || msg.Instance.Name == "" {
+			continue
+		}
+		r.apply(msg)
+	}
+}
+
+func (r *Registry) apply(msg *message) {
+	key := instanceKey{name: msg.Instance.Name, id: msg.Instance.ID}
+	switch msg.Type {
+	case typeAnnounce:
+		ttl := r.ttl
+		if msg.TTL > 0 {
+			ttl = time.Duration(msg.TTL) * time.Millisecond
+		}
+		r.mu.Lock()
+		r.seen[key] = time.Now().Add(ttl)
+		r.mu.Unlock()
+		_ = r.view.Register(r.ctx, msg.Instance)
+	case typeBye:
+		r.mu.Lock()
+		delete(r.seen, key)
+		r.mu.Unlock()
+		_ = r.view.Deregister(r.ctx, msg.Instance)
+	}
+}
+
+func (r *Registry) announce() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		r.mu.Lock()
+		for _, svc := range r.local {
+			if err := r.publish(typeAnnounce, svc); err != nil && r.ctx.Err() == nil {
+				log.Errorf("[multicast] failed to announce %s: %v", svc.ID, err)
+			}
+		}
+		r.mu.Unlock()
+		r.expire()
+	}
+}
+
+// expire removes the peers which stopped announcing.
+func (r *Registry) expire() {
+	now := time.Now()
+	var expired []instanceKey
+	r.mu.Lock()
+	for key, deadline := range r.seen {
+		if now.After(deadline) {
+			expired = append(expired, key)
+			delete(r.seen, key)
+		}
+	}
+	r.mu.Unlock()
+	for _, key := range expired {
+		_ = r.view.Deregister(r.ctx, &registry.ServiceInstance{ID: key.id, Name: key.name})
+	}
+}
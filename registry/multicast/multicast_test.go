@@ -0,0 +1,118 @@
+package multicast
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/apus-run/gaea/registry"
+)
+
+const testGroup = "239.255.77.77:17777"
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+	r, err := New(
+		WithGroup(testGroup),
+		WithInterface(lo),
+		WithAnnounceInterval(20*time.Millisecond),
+	)
+	if err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+	return r
+}
+
+func instance(id, name string) *registry.ServiceInstance {
+	return &registry.ServiceInstance{
+		ID:        id,
+		Name:      name,
+		Version:   "v1.0.0",
+		Metadata:  map[string]string{"zone": "a"},
+		Endpoints: []string{"grpc://127.0.0.1:9000"},
+	}
+}
+
+func waitFor(t *testing.T, w registry.Watcher, n int) []*registry.ServiceInstance {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for {
+		ch := make(chan []*registry.ServiceInstance, 1)
+		go func() {
+			ins, _ := w.Next()
+			ch <- ins
+		}()
+		select {
+		case ins := <-ch:
+			if len(ins) == n {
+				return ins
+			}
+		case <-deadline:
+			t.Fatalf("expect %d instances", n)
+			return nil
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(WithGroup("127.0.0.1:7777"))
+	assert.NotNil(t, err)
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	r1 := newTestRegistry(t)
+	r2 := newTestRegistry(t)
+	defer r2.Close()
+
+	w, err := r2.Watch(ctx, "helloworld")
+	assert.Nil(t, err)
+	defer w.Stop()
+
+	svc := instance("1", "helloworld")
+	assert.Nil(t, r1.Register(ctx, svc))
+	ins := waitFor(t, w, 1)
+	assert.Equal(t, svc, ins[0])
+
+	// the registering peer sees itself too
+	ins, err = r1.GetService(ctx, "helloworld")
+	assert.Nil(t, err)
+	assert.Len(t, ins, 1)
+
+	assert.Nil(t, r1.Register(ctx, instance("2", "helloworld")))
+	waitFor(t, w, 2)
+
+	assert.Nil(t, r1.Deregister(ctx, svc))
+	waitFor(t, w, 1)
+
+	// bye on close
+	assert.Nil(t, r1.Close())
+	waitFor(t, w, 0)
+}
+
+func TestRegistry_Expire(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRegistry(t)
+	defer r.Close()
+
+	w, err := r.Watch(ctx, "helloworld")
+	assert.Nil(t, err)
+	defer w.Stop()
+
+	// a peer announces once and disappears
+	b, err := json.Marshal(&message{Type: typeAnnounce, TTL: 100, Instance: instance("1", "helloworld")})
+	assert.Nil(t, err)
+	_, err = r.send.WriteToUDP(b, r.group)
+	assert.Nil(t, err)
+
+	waitFor(t, w, 1)
+	waitFor(t, w, 0)
+}
@@ -0,0 +1,199 @@
+// Package chash implements a consistent hash balancer, the requests with
+// the same key are routed to the same SubConn and only the keys of the
+// added or removed SubConns are remapped.
+package chash
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// Name is the name of consistent hash balancer.
+const Name = "gaea_consistent_hash"
+
+// DefaultReplicas is the default number of virtual nodes of each SubConn
+// on the ring.
+const DefaultReplicas = 160
+
+// Config is the load balancing config of consistent hash balancer.
+type Config struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	// HashKey is the outgoing metadata key whose value is hashed.
+	HashKey string `json:"hashKey,omitempty"`
+	// Replicas is the number of virtual nodes of each SubConn.
+	Replicas int `json:"replicas,omitempty"`
+}
+
+type keyCtx struct{}
+
+// WithKey returns a new Context that carries the hash key, it takes
+// precedence over the configured outgoing metadata key.
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyCtx{}, key)
+}
+
+// KeyFromContext returns the hash key stored in ctx, if any.
+func KeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(keyCtx{}).(string)
+	return key, ok
+}
+
+func init() {
+	balancer.Register(&builder{})
+}
+
+type builder struct{}
+
+func (*builder) Name() string {
+	return Name
+}
+
+func (*builder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &Config{}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, fmt.Errorf("chash: unable to unmarshal config %s: %w", js, err)
+	}
+	if cfg.Replicas < 0 {
+		return nil, fmt.Errorf("chash: invalid replicas %d", cfg.Replicas)
+	}
+	if cfg.Replicas == 0 {
+		cfg.Replicas = DefaultReplicas
+	}
+	return cfg, nil
+}
+
+func (*builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &pickerBuilder{cfg: &Config{Replicas: DefaultReplicas}}
+	return &chashBalancer{
+		Balancer: base.NewBalancerBuilder(Name, pb, base.Config{HealthCheck: true}).Build(cc, opts),
+		pb:       pb,
+	}
+}
+
+// chashBalancer hands the config to the picker builder before the base
+// balancer regenerates the picker.
+type chashBalancer struct {
+	balancer.Balancer
+	pb *pickerBuilder
+}
+
+func (b *chashBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	if cfg, ok := s.BalancerConfig.(*Config); ok {
+		b.pb.mu.Lock()
+		b.pb.cfg = cfg
+		b.pb.mu.Unlock()
+	}
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+type pickerBuilder struct {
+	mu  sync.Mutex
+	cfg *Config
+}
+
+// Build places the virtual nodes of the ready SubConns on the ring, the
+// positions only depend on the addresses, so a SubConn keeps its keys
+// across pickers.
+func (pb *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	pb.mu.Lock()
+	cfg := pb.cfg
+	pb.mu.Unlock()
+
+	p := &picker{
+		hashKey: cfg.HashKey,
+		scs:     make([]balancer.SubConn, 0, len(info.ReadySCs)),
+		ring:    make([]vnode, 0, len(info.ReadySCs)*cfg.Replicas),
+	}
+	for sc, sci := range info.ReadySCs {
+		p.scs = append(p.scs, sc)
+		for i := 0; i < cfg.Replicas; i++ {
+			p.ring = append(p.ring, vnode{
+				hash: hash(sci.Address.Addr + "#" + strconv.Itoa(i)),
+				addr: sci.Address.Addr,
+				sc:   sc,
+			})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool {
+		if p.ring[i].hash == p.ring[j].hash {
+			return p.ring[i].addr < p.ring[j].addr
+		}
+		return p.ring[i].hash < p.ring[j].hash
+	})
+	return p
+}
+
+type vnode struct {
+	hash uint64
+	addr string
+	sc   balancer.SubConn
+}
+
+type picker struct {
+	hashKey string
+	scs     []balancer.SubConn
+	ring    []vnode
+}
+
+// Pick picks the first virtual node clockwise from the hash of the key,
+// a request without a key is routed to a random SubConn.
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	key, ok := p.key(info.Ctx)
+	if !ok {
+		return balancer.PickResult{SubConn: p.scs[rand.Intn(len(p.scs))]}, nil
+	}
+	h := hash(key)
+	i := sort.Search(len(p.ring), func(i int) bool {
+		return p.ring[i].hash >= h
+	})
+	if i == len(p.ring) {
+		i = 0
+	}
+	return balancer.PickResult{SubConn: p.ring[i].sc}, nil
+}
+
+func (p *picker) key(ctx context.Context) (string, bool) {
+	if key, ok := KeyFromContext(ctx); ok {
+		return key, true
+	}
+	if p.hashKey == "" {
+		return "", false
+	}
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	if vals := md.Get(p.hashKey); len(vals) > 0 {
+		return vals[0], true
+	}
+	return "", false
+}
+
+// hash hashes s with FNV-1a, followed by the murmur3 finalizer to spread
+// the similar strings of the virtual nodes over the ring.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,119 @@
+package chash
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+)
+
+type testSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func TestParseConfig(t *testing.T) {
+	b := &builder{}
+	cfg, err := b.ParseConfig([]byte(`{"hashKey":"x-user-id"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cfg.(*Config)
+	if c.HashKey != "x-user-id" || c.Replicas != DefaultReplicas {
+		t.Errorf("unexpected config %+v", c)
+	}
+	if _, err = b.ParseConfig([]byte(`{"replicas":-1}`)); err == nil {
+		t.Error("expect invalid replicas error")
+	}
+}
+
+func build(pb *pickerBuilder, names ...string) balancer.Picker {
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for _, name := range names {
+		info.ReadySCs[&testSubConn{name: name}] = base.SubConnInfo{Address: resolver.Address{Addr: name}}
+	}
+	return pb.Build(info)
+}
+
+func pick(t *testing.T, p balancer.Picker, ctx context.Context) string {
+	t.Helper()
+	res, err := p.Pick(balancer.PickInfo{Ctx: ctx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.SubConn.(*testSubConn).name
+}
+
+func TestPicker_Affinity(t *testing.T) {
+	pb := &pickerBuilder{cfg: &Config{HashKey: "x-user-id", Replicas: DefaultReplicas}}
+	p := build(pb, "a", "b", "c")
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-user-id", "42")
+	want := pick(t, p, ctx)
+	for i := 0; i < 10; i++ {
+		if got := pick(t, p, ctx); got != want {
+			t.Fatalf("expect %s, got %s", want, got)
+		}
+	}
+	// a rebuilt picker keeps the mapping
+	if got := pick(t, build(pb, "c", "b", "a"), ctx); got != want {
+		t.Errorf("expect %s after rebuilding, got %s", want, got)
+	}
+
+	// the context key takes precedence
+	a := pick(t, p, WithKey(context.Background(), "42"))
+	b := pick(t, p, WithKey(ctx, "42"))
+	if a != want || b != want {
+		t.Errorf("expect %s, got %s and %s", want, a, b)
+	}
+}
+
+func TestPicker_Remap(t *testing.T) {
+	pb := &pickerBuilder{cfg: &Config{Replicas: DefaultReplicas}}
+	before := build(pb, "a", "b", "c", "d")
+	after := build(pb, "a", "b", "c", "d", "e")
+
+	const n = 10000
+	counts := make(map[string]int)
+	moved := 0
+	for i := 0; i < n; i++ {
+		ctx := WithKey(context.Background(), fmt.Sprintf("user-%d", i))
+		x, y := pick(t, before, ctx), pick(t, after, ctx)
+		counts[x]++
+		if x != y {
+			moved++
+			if y != "e" {
+				t.Fatalf("key moved from %s to %s instead of the new instance", x, y)
+			}
+		}
+	}
+	// about 1/5 of the keys move to the new instance
+	if moved < n/10 || moved > n*3/10 {
+		t.Errorf("expect about %d keys to move, got %d", n/5, moved)
+	}
+	for name, c := range counts {
+		if c < n/4/2 || c > n/4*2 {
+			t.Errorf("unbalanced ring, %s got %d keys", name, c)
+		}
+	}
+}
+
+func TestPicker_NoKey(t *testing.T) {
+	pb := &pickerBuilder{cfg: &Config{HashKey: "x-user-id", Replicas: DefaultReplicas}}
+	p := build(pb, "a", "b", "c")
+	got := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		got[pick(t, p, context.Background())] = true
+	}
+	if len(got) != 3 {
+		t.Errorf("expect requests without key to be spread, got %v", got)
+	}
+
+	if _, err := pb.Build(base.PickerBuildInfo{}).Pick(balancer.PickInfo{}); err != balancer.ErrNoSubConnAvailable {
+		t.Errorf("expect ErrNoSubConnAvailable, got %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+// Package locality implements a balancer which prefers the SubConns in the
+// same zone, then in the same region as the caller, and spills over to the
+// other localities when the local healthy capacity is too low.
+package locality
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// Name is the name of locality balancer.
+const Name = "gaea_locality"
+
+const (
+	// ZoneKey is the instance metadata key holding the zone.
+	ZoneKey = "zone"
+	// RegionKey is the instance metadata key holding the region.
+	RegionKey = "region"
+)
+
+// DefaultFailoverRatio is the default ratio of ready endpoints under which
+// the traffic spills over to the next locality.
+const DefaultFailoverRatio = 0.5
+
+// Config is the load balancing config of locality balancer.
+type Config struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	// Zone is the zone of the caller.
+	Zone string `json:"zone,omitempty"`
+	// Region is the region of the caller.
+	Region string `json:"region,omitempty"`
+	// FailoverRatio is the ratio of ready endpoints in a locality under
+	// which the traffic spills over to the next locality.
+	FailoverRatio float64 `json:"failoverRatio,omitempty"`
+}
+
+func init() {
+	balancer.Register(&builder{})
+}
+
+type builder struct{}
+
+func (*builder) Name() string {
+	return Name
+}
+
+func (*builder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &Config{}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, fmt.Errorf("locality: unable to unmarshal config %s: %w", js, err)
+	}
+	if cfg.FailoverRatio < 0 || cfg.FailoverRatio > 1 {
+		return nil, fmt.Errorf("locality: failover ratio %v out of range [0, 1]", cfg.FailoverRatio)
+	}
+	if cfg.FailoverRatio == 0 {
+		cfg.FailoverRatio = DefaultFailoverRatio
+	}
+	return cfg, nil
+}
+
+func (*builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &pickerBuilder{cfg: &Config{FailoverRatio: DefaultFailoverRatio}}
+	return &localityBalancer{
+		Balancer: base.NewBalancerBuilder(Name, pb, base.Config{HealthCheck: true}).Build(cc, opts),
+		pb:       pb,
+	}
+}
+
+// localityBalancer records the config and the localities of all the
+// resolved endpoints, ready or not, before handing the resolver state to
+// the base balancer.
+type localityBalancer struct {
+	balancer.Balancer
+	pb *pickerBuilder
+}
+
+func (b *localityBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	addrs := make(map[string]locality, len(s.ResolverState.Addresses))
+	for _, addr := range s.ResolverState.Addresses {
+		addrs[addr.Addr] = localityOf(addr)
+	}
+	b.pb.mu.Lock()
+	if cfg, ok := s.BalancerConfig.(*Config); ok {
+		b.pb.cfg = cfg
+	}
+	b.pb.addrs = addrs
+	b.pb.mu.Unlock()
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+type locality struct {
+	zone   string
+	region string
+}
+
+func localityOf(addr resolver.Address) locality {
+	var l locality
+	if addr.Attributes == nil {
+		return l
+	}
+	l.zone, _ = addr.Attributes.Value(ZoneKey).(string)
+	l.region, _ = addr.Attributes.Value(RegionKey).(string)
+	return l
+}
+
+type pickerBuilder struct {
+	mu    sync.Mutex
+	cfg   *Config
+	addrs map[string]locality
+}
+
+func (pb *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	var (
+		cfg                    = pb.cfg
+		zone, region, all      []balancer.SubConn
+		zoneTotal, regionTotal int
+	)
+	for _, l := range pb.addrs {
+		if cfg.sameZone(l) {
+			zoneTotal++
+		}
+		if cfg.sameRegion(l) {
+			regionTotal++
+		}
+	}
+	for sc, sci := range info.ReadySCs {
+		l, ok := pb.addrs[sci.Address.Addr]
+		if !ok {
+			l = localityOf(sci.Address)
+		}
+		if cfg.sameZone(l) {
+			zone = append(zone, sc)
+		}
+		if cfg.sameRegion(l) {
+			region = append(region, sc)
+		}
+		all = append(all, sc)
+	}
+
+	scs := all
+	switch {
+	case healthy(len(zone), zoneTotal, cfg.FailoverRatio):
+		scs = zone
+	case healthy(len(region), regionTotal, cfg.FailoverRatio):
+		scs = region
+	}
+	return &picker{
+		scs:  scs,
+		next: uint32(rand.Intn(len(scs))),
+	}
+}
+
+func (c *Config) sameZone(l locality) bool {
+	return c.Zone != "" && l.zone == c.Zone && (c.Region == "" || l.region == c.Region)
+}
+
+func (c *Config) sameRegion(l locality) bool {
+	return c.Region != "" && l.region == c.Region
+}
+
+// healthy reports whether a locality has enough ready endpoints to serve
+// the traffic on its own.
+func healthy(ready, total int, ratio float64) bool {
+	if ready == 0 || total == 0 {
+		return false
+	}
+	return float64(ready)/float64(total) >= ratio
+}
+
+// picker round-robins over the SubConns of the selected locality.
+type picker struct {
+	scs  []balancer.SubConn
+	next uint32
+}
+
+func (p *picker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	n := atomic.AddUint32(&p.next, 1)
+	return balancer.PickResult{SubConn: p.scs[n%uint32(len(p.scs))]}, nil
+}
@@ -0,0 +1,122 @@
+package locality
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+type testSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func TestParseConfig(t *testing.T) {
+	b := &builder{}
+	cfg, err := b.ParseConfig([]byte(`{"zone":"a","region":"r"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cfg.(*Config)
+	if c.Zone != "a" || c.Region != "r" || c.FailoverRatio != DefaultFailoverRatio {
+		t.Errorf("unexpected config %+v", c)
+	}
+
+	if _, err = b.ParseConfig([]byte(`{"failoverRatio":2}`)); err == nil {
+		t.Error("expect out of range error")
+	}
+	if _, err = b.ParseConfig([]byte(`{`)); err == nil {
+		t.Error("expect unmarshal error")
+	}
+}
+
+func addr(name, zone, region string) resolver.Address {
+	return resolver.Address{
+		Addr:       name,
+		Attributes: attributes.New(ZoneKey, zone).WithValue(RegionKey, region),
+	}
+}
+
+func TestPickerBuilder(t *testing.T) {
+	addrs := []resolver.Address{
+		addr("a1", "a", "r1"),
+		addr("a2", "a", "r1"),
+		addr("b1", "b", "r1"),
+		addr("b2", "b", "r1"),
+		addr("c1", "c", "r2"),
+	}
+	pb := &pickerBuilder{
+		cfg:   &Config{Zone: "a", Region: "r1", FailoverRatio: DefaultFailoverRatio},
+		addrs: make(map[string]locality),
+	}
+	for _, a := range addrs {
+		pb.addrs[a.Addr] = localityOf(a)
+	}
+	scs := make(map[string]balancer.SubConn)
+	for _, a := range addrs {
+		scs[a.Addr] = &testSubConn{name: a.Addr}
+	}
+
+	picked := func(ready ...string) map[string]bool {
+		info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+		for _, name := range ready {
+			info.ReadySCs[scs[name]] = base.SubConnInfo{Address: resolver.Address{Addr: name}}
+		}
+		p := pb.Build(info)
+		got := make(map[string]bool)
+		for i := 0; i < 20; i++ {
+			res, err := p.Pick(balancer.PickInfo{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[res.SubConn.(*testSubConn).name] = true
+		}
+		return got
+	}
+
+	tests := []struct {
+		name  string
+		ready []string
+		want  []string
+	}{
+		{"same zone", []string{"a1", "a2", "b1", "b2", "c1"}, []string{"a1", "a2"}},
+		{"half of the zone is enough", []string{"a1", "b1", "c1"}, []string{"a1"}},
+		{"spill over to the region", []string{"b1", "b2", "c1"}, []string{"b1", "b2"}},
+		{"spill over to all", []string{"b1", "c1"}, []string{"b1", "c1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := picked(tt.ready...)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expect %v, got %v", tt.want, got)
+			}
+			for _, name := range tt.want {
+				if !got[name] {
+					t.Fatalf("expect %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+
+	// a stricter ratio spills over as soon as an instance is down
+	pb.cfg.FailoverRatio = 1
+	if got := picked("a1", "b1", "b2", "c1"); len(got) != 4 {
+		t.Errorf("expect all instances, got %v", got)
+	}
+
+	// no locality configured
+	pb.cfg = &Config{FailoverRatio: DefaultFailoverRatio}
+	if got := picked("a1", "b1", "c1"); len(got) != 3 {
+		t.Errorf("expect all instances, got %v", got)
+	}
+}
+
+func TestPickerBuilder_NoReadySubConn(t *testing.T) {
+	pb := &pickerBuilder{cfg: &Config{}}
+	if _, err := pb.Build(base.PickerBuildInfo{}).Pick(balancer.PickInfo{}); err != balancer.ErrNoSubConnAvailable {
+		t.Errorf("expect ErrNoSubConnAvailable, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+// Package p2c implements a power of two choices balancer which picks the
+// less loaded of two random SubConns, the load is estimated from the
+// in-flight requests and the EWMA latency of each SubConn.
+package p2c
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// Name is the name of p2c balancer.
+const Name = "gaea_p2c"
+
+const (
+	// decayTime is the time constant of the latency EWMA.
+	decayTime = 600 * time.Millisecond
+	// forcePick is the duration after which a SubConn is picked regardless
+	// of its load, so that the stats of a slow SubConn get refreshed.
+	forcePick = 3 * time.Second
+)
+
+func init() {
+	balancer.Register(&builder{})
+}
+
+type builder struct{}
+
+func (*builder) Name() string {
+	return Name
+}
+
+func (*builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &pickerBuilder{nodes: make(map[balancer.SubConn]*node)}
+	return base.NewBalancerBuilder(Name, pb, base.Config{HealthCheck: true}).Build(cc, opts)
+}
+
+// pickerBuilder keeps the stats of the SubConns across pickers, the stats
+// of a SubConn are dropped once it is no longer ready.
+type pickerBuilder struct {
+	mu    sync.Mutex
+	nodes map[balancer.SubConn]*node
+}
+
+func (pb *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	nodes := make(map[balancer.SubConn]*node, len(info.ReadySCs))
+	p := &picker{nodes: make([]*node, 0, len(info.ReadySCs))}
+	for sc := range info.ReadySCs {
+		n, ok := pb.nodes[sc]
+		if !ok {
+			n = newNode(sc)
+		}
+		nodes[sc] = n
+		p.nodes = append(p.nodes, n)
+	}
+	pb.nodes = nodes
+	return p
+}
+
+type picker struct {
+	nodes []*node
+}
+
+// Pick picks two distinct random SubConns and returns the one with the
+// lower load.
+func (p *picker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	var n *node
+	if len(p.nodes) == 1 {
+		n = p.nodes[0]
+	} else {
+		a := rand.Intn(len(p.nodes))
+		b := rand.Intn(len(p.nodes) - 1)
+		if b >= a {
+			b++
+		}
+		n = choose(p.nodes[a], p.nodes[b])
+	}
+
+	start := n.start()
+	return balancer.PickResult{
+		SubConn: n.sc,
+		Done: func(balancer.DoneInfo) {
+			n.done(start)
+		},
+	}, nil
+}
+
+func choose(a, b *node) *node {
+	if a.load() > b.load() {
+		a, b = b, a
+	}
+	// the loaded one has not been picked for a while, give it a chance
+	// to refresh its latency.
+	if time.Since(time.Unix(0, b.lastPick.Load())) > forcePick {
+		return b
+	}
+	return a
+}
+
+type node struct {
+	sc balancer.SubConn
+
+	inflight atomic.Int64
+	// lag is the EWMA latency in nanoseconds.
+	lag atomic.Int64
+	// stamp is the unix nano of the last latency update.
+	stamp    atomic.Int64
+	lastPick atomic.Int64
+}
+
+func newNode(sc balancer.SubConn) *node {
+	n := &node{sc: sc}
+	now := time.Now().UnixNano()
+	n.stamp.Store(now)
+	n.lastPick.Store(now)
+	return n
+}
+
+func (n *node) start() time.Time {
+	now := time.Now()
+	n.inflight.Add(1)
+	n.lastPick.Store(now.UnixNano())
+	return now
+}
+
+func (n *node) done(start time.Time) {
+	n.inflight.Add(-1)
+
+	now := time.Now()
+	rtt := now.Sub(start).Nanoseconds()
+	td := now.UnixNano() - n.stamp.Swap(now.UnixNano())
+	if td < 0 {
+		td = 0
+	}
+	w := math.Exp(-float64(td) / float64(decayTime))
+	lag := n.lag.Load()
+	n.lag.Store(int64(float64(lag)*w + float64(rtt)*(1-w)))
+}
+
+// load estimates the load of the node, a node without any latency sample
+// has the lowest load so that it gets probed first.
+func (n *node) load() int64 {
+	return (n.lag.Load() + 1) * (n.inflight.Load() + 1)
+}
@@ -0,0 +1,136 @@
+package p2c
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+type testSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func TestRegistered(t *testing.T) {
+	if balancer.Get(Name) == nil {
+		t.Fatalf("balancer %q is not registered", Name)
+	}
+}
+
+func TestChoose(t *testing.T) {
+	a := newNode(&testSubConn{name: "a"})
+	b := newNode(&testSubConn{name: "b"})
+
+	// in-flight requests
+	a.start()
+	if got := choose(a, b); got != b {
+		t.Errorf("expect the node without in-flight requests")
+	}
+	a.inflight.Add(-1)
+
+	// latency
+	a.lag.Store(int64(10 * time.Millisecond))
+	b.lag.Store(int64(time.Millisecond))
+	if got := choose(a, b); got != b {
+		t.Errorf("expect the node with lower latency")
+	}
+
+	// force pick
+	a.lastPick.Store(time.Now().Add(-2 * forcePick).UnixNano())
+	if got := choose(a, b); got != a {
+		t.Errorf("expect the node which has not been picked for a while")
+	}
+}
+
+func TestNode_Done(t *testing.T) {
+	n := newNode(&testSubConn{})
+	n.stamp.Store(time.Now().Add(-10 * decayTime).UnixNano())
+	start := n.start()
+	time.Sleep(10 * time.Millisecond)
+	n.done(start)
+	if n.inflight.Load() != 0 {
+		t.Errorf("expect no in-flight request, got %d", n.inflight.Load())
+	}
+	if lag := time.Duration(n.lag.Load()); lag < 10*time.Millisecond {
+		t.Errorf("expect latency >= 10ms, got %v", lag)
+	}
+}
+
+func TestPickerBuilder_KeepStats(t *testing.T) {
+	pb := &pickerBuilder{nodes: make(map[balancer.SubConn]*node)}
+	a := &testSubConn{name: "a"}
+	b := &testSubConn{name: "b"}
+
+	pb.Build(base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{a: {}, b: {}}})
+	pb.nodes[a].lag.Store(42)
+
+	pb.Build(base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{a: {}}})
+	if len(pb.nodes) != 1 || pb.nodes[a].lag.Load() != 42 {
+		t.Errorf("expect the stats of a to be kept and b to be dropped")
+	}
+
+	p := pb.Build(base.PickerBuildInfo{})
+	if _, err := p.Pick(balancer.PickInfo{}); err != balancer.ErrNoSubConnAvailable {
+		t.Errorf("expect ErrNoSubConnAvailable, got %v", err)
+	}
+}
+
+func startServer(t *testing.T, delay time.Duration, hits *atomic.Int64) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		hits.Add(1)
+		time.Sleep(delay)
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func TestBalancer_PreferFastBackend(t *testing.T) {
+	var fastHits, slowHits atomic.Int64
+	fast := startServer(t, 0, &fastHits)
+	slow := startServer(t, 20*time.Millisecond, &slowHits)
+
+	r := manual.NewBuilderWithScheme("p2c")
+	r.InitialState(resolver.State{Addresses: []resolver.Address{{Addr: fast}, {Addr: slow}}})
+	conn, err := grpc.Dial(r.Scheme()+":///test",
+		grpc.WithResolvers(r),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s":{}}]}`, Name)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	for i := 0; i < 200; i++ {
+		if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fastHits.Load() < 180 {
+		t.Errorf("expect most requests on the fast backend, got fast %d slow %d", fastHits.Load(), slowHits.Load())
+	}
+	if slowHits.Load() == 0 {
+		t.Errorf("expect the slow backend to be probed")
+	}
+}
@@ -0,0 +1,225 @@
+// Package pick implements a balancer which wraps another balancer and hands
+// the address of every picked SubConn to the middleware.PickFunc carried by
+// the context of the call, such as to keep a circuit breaker per backend.
+package pick
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+
+	"github.com/apus-run/gaea/middleware"
+)
+
+// Name is the name of pick balancer.
+const Name = "gaea_pick"
+
+// maxPicks is the number of picks of a call before giving up when the
+// PickFunc rejects the picked backends.
+const maxPicks = 3
+
+// Config is the load balancing config of pick balancer.
+type Config struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	// Child is the name of the wrapped balancer.
+	Child string `json:"child"`
+	// ChildConfig is the load balancing config of the wrapped balancer.
+	ChildConfig json.RawMessage `json:"childConfig,omitempty"`
+
+	childConfig serviceconfig.LoadBalancingConfig
+}
+
+func init() {
+	balancer.Register(&builder{})
+}
+
+type builder struct{}
+
+func (*builder) Name() string {
+	return Name
+}
+
+func (*builder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &Config{}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, fmt.Errorf("pick: unable to unmarshal config %s: %w", js, err)
+	}
+	child := balancer.Get(cfg.Child)
+	if child == nil {
+		return nil, fmt.Errorf("pick: child balancer %q is not registered", cfg.Child)
+	}
+	if p, ok := child.(balancer.ConfigParser); ok {
+		childJS := cfg.ChildConfig
+		if len(childJS) == 0 {
+			childJS = json.RawMessage("{}")
+		}
+		childCfg, err := p.ParseConfig(childJS)
+		if err != nil {
+			return nil, err
+		}
+		cfg.childConfig = childCfg
+	}
+	return cfg, nil
+}
+
+func (*builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	return &pickBalancer{cc: cc, opts: opts}
+}
+
+// pickBalancer builds the child balancer named by the config on a client
+// conn which wraps the SubConns and the pickers of the child.
+type pickBalancer struct {
+	cc    balancer.ClientConn
+	opts  balancer.BuildOptions
+	name  string
+	child *clientConn
+}
+
+func (b *pickBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	cfg, ok := s.BalancerConfig.(*Config)
+	if !ok {
+		return fmt.Errorf("pick: unexpected config %T", s.BalancerConfig)
+	}
+	if b.child == nil || b.name != cfg.Child {
+		if b.child != nil {
+			b.child.Close()
+		}
+		b.name = cfg.Child
+		b.child = &clientConn{ClientConn: b.cc}
+		b.child.Balancer = balancer.Get(cfg.Child).Build(b.child, b.opts)
+	}
+	s.BalancerConfig = cfg.childConfig
+	return b.child.UpdateClientConnState(s)
+}
+
+func (b *pickBalancer) ResolverError(err error) {
+	if b.child != nil {
+		b.child.ResolverError(err)
+	}
+}
+
+// UpdateSubConnState is a nop because a StateListener is always set in
+// NewSubConn.
+func (b *pickBalancer) UpdateSubConnState(balancer.SubConn, balancer.SubConnState) {}
+
+func (b *pickBalancer) Close() {
+	if b.child != nil {
+		b.child.Close()
+	}
+}
+
+func (b *pickBalancer) ExitIdle() {
+	if b.child == nil {
+		return
+	}
+	if ei, ok := b.child.Balancer.(balancer.ExitIdler); ok {
+		ei.ExitIdle()
+	}
+}
+
+// clientConn is the client conn of a child balancer.
+type clientConn struct {
+	balancer.ClientConn
+	// Balancer is the child balancer
+	balancer.Balancer
+}
+
+// subConn records the address of a SubConn of the child balancer.
+type subConn struct {
+	balancer.SubConn
+	addr string
+}
+
+func (cc *clientConn) NewSubConn(addrs []resolver.Address, opts balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	sc := &subConn{}
+	if len(addrs) > 0 {
+		sc.addr = addrs[0].Addr
+	}
+	listener := opts.StateListener
+	opts.StateListener = func(s balancer.SubConnState) {
+		if listener != nil {
+			listener(s)
+			return
+		}
+		cc.Balancer.UpdateSubConnState(sc, s)
+	}
+	inner, err := cc.ClientConn.NewSubConn(addrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	sc.SubConn = inner
+	return sc, nil
+}
+
+func (cc *clientConn) RemoveSubConn(sc balancer.SubConn) {
+	if w, ok := sc.(*subConn); ok {
+		sc = w.SubConn
+	}
+	cc.ClientConn.RemoveSubConn(sc)
+}
+
+func (cc *clientConn) UpdateAddresses(sc balancer.SubConn, addrs []resolver.Address) {
+	if w, ok := sc.(*subConn); ok {
+		sc = w.SubConn
+	}
+	cc.ClientConn.UpdateAddresses(sc, addrs)
+}
+
+func (cc *clientConn) UpdateState(s balancer.State) {
+	s.Picker = &picker{Picker: s.Picker}
+	cc.ClientConn.UpdateState(s)
+}
+
+type picker struct {
+	balancer.Picker
+}
+
+// Pick picks with the child picker, and picks again when the PickFunc of
+// the call rejects the picked backend.
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	fn, hooked := middleware.PickFromContext(info.Ctx)
+	var err error
+	for i := 0; i < maxPicks; i++ {
+		var res balancer.PickResult
+		res, err = p.Picker.Pick(info)
+		if err != nil {
+			return res, err
+		}
+		sc, ok := res.SubConn.(*subConn)
+		if !ok {
+			return res, nil
+		}
+		res.SubConn = sc.SubConn
+		if !hooked {
+			return res, nil
+		}
+		var done func(error)
+		if done, err = fn(sc.addr); err != nil {
+			// the call never goes through the picked SubConn
+			if res.Done != nil {
+				res.Done(balancer.DoneInfo{Err: err})
+			}
+			continue
+		}
+		if done != nil {
+			next := res.Done
+			res.Done = func(di balancer.DoneInfo) {
+				if next != nil {
+					next(di)
+				}
+				if di.Err == nil && !di.BytesSent {
+					// gRPC picks again when the transport is not ready
+					done(middleware.ErrNotSent)
+					return
+				}
+				done(di.Err)
+			}
+		}
+		return res, nil
+	}
+	return balancer.PickResult{}, err
+}
@@ -0,0 +1,159 @@
+package pick
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/roundrobin"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+
+	"github.com/apus-run/gaea/middleware"
+)
+
+var errRejected = errors.New("rejected")
+
+type testSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+// testPicker picks the SubConns in turn and counts the done calls.
+type testPicker struct {
+	scs  []balancer.SubConn
+	next int
+	done int
+}
+
+func (p *testPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	sc := p.scs[p.next%len(p.scs)]
+	p.next++
+	return balancer.PickResult{SubConn: sc, Done: func(balancer.DoneInfo) { p.done++ }}, nil
+}
+
+func TestRegistered(t *testing.T) {
+	if balancer.Get(Name) == nil {
+		t.Fatalf("balancer %q is not registered", Name)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	b := balancer.Get(Name).(balancer.ConfigParser)
+	cfg, err := b.ParseConfig([]byte(`{"child":"round_robin"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.(*Config).Child != roundrobin.Name {
+		t.Errorf("expect child %s, got %s", roundrobin.Name, cfg.(*Config).Child)
+	}
+	if _, err = b.ParseConfig([]byte(`{"child":"unknown"}`)); err == nil {
+		t.Error("expect an error for an unregistered child")
+	}
+}
+
+func TestPicker(t *testing.T) {
+	a := &subConn{SubConn: &testSubConn{name: "a"}, addr: "a"}
+	b := &subConn{SubConn: &testSubConn{name: "b"}, addr: "b"}
+	child := &testPicker{scs: []balancer.SubConn{a, b}}
+	p := &picker{Picker: child}
+
+	// without PickFunc
+	res, err := p.Pick(balancer.PickInfo{Ctx: context.Background()})
+	if err != nil || res.SubConn != a.SubConn {
+		t.Fatalf("expect the unwrapped SubConn a, got %v %v", res.SubConn, err)
+	}
+
+	var outcomes []error
+	ctx := middleware.NewPickContext(context.Background(), func(addr string) (func(error), error) {
+		if addr == "a" {
+			return nil, errRejected
+		}
+		return func(err error) { outcomes = append(outcomes, err) }, nil
+	})
+	child.next, child.done = 0, 0
+	res, err = p.Pick(balancer.PickInfo{Ctx: ctx})
+	if err != nil || res.SubConn != b.SubConn {
+		t.Fatalf("expect SubConn b, got %v %v", res.SubConn, err)
+	}
+	if child.done != 1 {
+		t.Errorf("expect the rejected pick to be done, got %d", child.done)
+	}
+	res.Done(balancer.DoneInfo{BytesSent: true})
+	res.Done(balancer.DoneInfo{})
+	if child.done != 3 || len(outcomes) != 2 || outcomes[0] != nil || outcomes[1] != middleware.ErrNotSent {
+		t.Errorf("unexpected outcomes %v", outcomes)
+	}
+
+	// every backend rejected
+	child.scs = []balancer.SubConn{a}
+	if _, err = p.Pick(balancer.PickInfo{Ctx: ctx}); err != errRejected {
+		t.Errorf("expect %v, got %v", errRejected, err)
+	}
+}
+
+func startServer(t *testing.T, hits *atomic.Int64) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		hits.Add(1)
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func TestBalancer_SkipRejected(t *testing.T) {
+	var goodHits, badHits atomic.Int64
+	good := startServer(t, &goodHits)
+	bad := startServer(t, &badHits)
+
+	r := manual.NewBuilderWithScheme("pick")
+	r.InitialState(resolver.State{Addresses: []resolver.Address{{Addr: good}, {Addr: bad}}})
+	conn, err := grpc.Dial(r.Scheme()+":///test",
+		grpc.WithResolvers(r),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s":{"child":"%s"}}]}`, Name, roundrobin.Name)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	var done atomic.Int64
+	ctx := middleware.NewPickContext(context.Background(), func(addr string) (func(error), error) {
+		if addr == bad {
+			return nil, errRejected
+		}
+		return func(err error) {
+			if err == nil {
+				done.Add(1)
+			}
+		}, nil
+	})
+	for i := 0; i < 20; i++ {
+		if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if goodHits.Load() != 20 || badHits.Load() != 0 {
+		t.Errorf("expect every request on the good backend, got good %d bad %d", goodHits.Load(), badHits.Load())
+	}
+	if done.Load() != 20 {
+		t.Errorf("expect 20 successful done calls, got %d", done.Load())
+	}
+}
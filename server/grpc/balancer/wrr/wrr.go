@@ -0,0 +1,94 @@
+// Package wrr implements a smooth weighted round-robin balancer which reads
+// the weight of each address from the "weight" metadata of its instance.
+package wrr
+
+import (
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+// Name is the name of weighted round-robin balancer.
+const Name = "gaea_weighted_round_robin"
+
+// WeightKey is the instance metadata key holding the weight.
+const WeightKey = "weight"
+
+// DefaultWeight is the weight of an address without a valid weight metadata.
+const DefaultWeight = 100
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(Name, &pickerBuilder{}, base.Config{HealthCheck: true}))
+}
+
+// pickerBuilder reads the weights from the addresses of the ready SubConns.
+// The SubConns are keyed by their address including its attributes, so a
+// new weight creates a new SubConn and reweighting reconnects the backend.
+type pickerBuilder struct{}
+
+func (*pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	p := &picker{items: make([]*item, 0, len(info.ReadySCs))}
+	for sc, sci := range info.ReadySCs {
+		p.items = append(p.items, &item{
+			sc:     sc,
+			weight: Weight(sci.Address),
+		})
+	}
+	return p
+}
+
+type item struct {
+	sc            balancer.SubConn
+	weight        int64
+	currentWeight int64
+}
+
+type picker struct {
+	mu    sync.Mutex
+	items []*item
+}
+
+// Pick picks the SubConn with the smooth weighted round-robin algorithm
+// used by nginx: every pick adds each weight to its current weight, selects
+// the largest one and subtracts the total weight from it.
+func (p *picker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var (
+		total    int64
+		selected *item
+	)
+	for _, it := range p.items {
+		it.currentWeight += it.weight
+		total += it.weight
+		if selected == nil || it.currentWeight > selected.currentWeight {
+			selected = it
+		}
+	}
+	selected.currentWeight -= total
+	return balancer.PickResult{SubConn: selected.sc}, nil
+}
+
+// Weight returns the weight of the address, DefaultWeight is returned when
+// the weight metadata is absent or not a positive integer.
+func Weight(addr resolver.Address) int64 {
+	if addr.Attributes == nil {
+		return DefaultWeight
+	}
+	v, ok := addr.Attributes.Value(WeightKey).(string)
+	if !ok {
+		return DefaultWeight
+	}
+	w, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || w <= 0 {
+		return DefaultWeight
+	}
+	return w
+}
@@ -0,0 +1,179 @@
+package wrr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+type testSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func addr(weight string) resolver.Address {
+	a := resolver.Address{Addr: "127.0.0.1:9000"}
+	if weight != "" {
+		a.Attributes = attributes.New(WeightKey, weight)
+	}
+	return a
+}
+
+func TestRegistered(t *testing.T) {
+	if balancer.Get(Name) == nil {
+		t.Fatalf("balancer %q is not registered", Name)
+	}
+}
+
+func TestWeight(t *testing.T) {
+	tests := []struct {
+		weight string
+		want   int64
+	}{
+		{"", DefaultWeight},
+		{"10", 10},
+		{"0", DefaultWeight},
+		{"-1", DefaultWeight},
+		{"abc", DefaultWeight},
+	}
+	for _, tt := range tests {
+		if got := Weight(addr(tt.weight)); got != tt.want {
+			t.Errorf("Weight(%q) = %d, want %d", tt.weight, got, tt.want)
+		}
+	}
+}
+
+func TestPicker(t *testing.T) {
+	a := &testSubConn{name: "a"}
+	b := &testSubConn{name: "b"}
+	c := &testSubConn{name: "c"}
+	p := (&pickerBuilder{}).Build(base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+		a: {Address: addr("5")},
+		b: {Address: addr("1")},
+		c: {Address: addr("1")},
+	}})
+
+	counts := make(map[string]int)
+	var last string
+	run := 0
+	for i := 0; i < 70; i++ {
+		res, err := p.Pick(balancer.PickInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := res.SubConn.(*testSubConn).name
+		counts[name]++
+		if name == last {
+			run++
+		} else {
+			last, run = name, 1
+		}
+		// smooth: the heaviest address is never picked 5 times in a row
+		if run > 4 {
+			t.Fatalf("%s picked %d times in a row", name, run)
+		}
+	}
+	if counts["a"] != 50 || counts["b"] != 10 || counts["c"] != 10 {
+		t.Errorf("unexpected distribution %v", counts)
+	}
+}
+
+func TestPicker_NoReadySubConn(t *testing.T) {
+	p := (&pickerBuilder{}).Build(base.PickerBuildInfo{})
+	if _, err := p.Pick(balancer.PickInfo{}); err != balancer.ErrNoSubConnAvailable {
+		t.Errorf("expect ErrNoSubConnAvailable, got %v", err)
+	}
+}
+
+func startServer(t *testing.T, hits *atomic.Int64) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		hits.Add(1)
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func TestBalancer_Reweight(t *testing.T) {
+	var hitsA, hitsB atomic.Int64
+	a := startServer(t, &hitsA)
+	b := startServer(t, &hitsB)
+
+	r := manual.NewBuilderWithScheme("wrr")
+	state := func(wa, wb string) resolver.State {
+		return resolver.State{Addresses: []resolver.Address{
+			{Addr: a, Attributes: attributes.New(WeightKey, wa)},
+			{Addr: b, Attributes: attributes.New(WeightKey, wb)},
+		}}
+	}
+	r.InitialState(state("3", "1"))
+	conn, err := grpc.Dial(r.Scheme()+":///test",
+		grpc.WithResolvers(r),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s":{}}]}`, Name)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	call := func(n int) {
+		for i := 0; i < n; i++ {
+			if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// wait for both backends to be ready
+	deadline := time.Now().Add(5 * time.Second)
+	for hitsA.Load() == 0 || hitsB.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("backends are not ready")
+		}
+		call(1)
+	}
+
+	hitsA.Store(0)
+	hitsB.Store(0)
+	call(40)
+	if hitsA.Load() != 30 || hitsB.Load() != 10 {
+		t.Errorf("expect 30/10, got %d/%d", hitsA.Load(), hitsB.Load())
+	}
+
+	// reweighting reconnects the backends, wait for the new picker
+	r.UpdateState(state("1", "3"))
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		hitsA.Store(0)
+		hitsB.Store(0)
+		call(40)
+		if hitsA.Load() == 10 && hitsB.Load() == 30 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect 10/30 after reweighting, got %d/%d", hitsA.Load(), hitsB.Load())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -3,6 +3,7 @@ package grpc
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -11,11 +12,25 @@ import (
 	"google.golang.org/grpc/credentials"
 	grpcInsecure "google.golang.org/grpc/credentials/insecure"
 
+	ic "github.com/apus-run/gaea/internal/context"
+	"github.com/apus-run/gaea/internal/selector"
 	"github.com/apus-run/gaea/middleware"
 	"github.com/apus-run/gaea/registry"
+	"github.com/apus-run/gaea/server/grpc/balancer/chash"
+	"github.com/apus-run/gaea/server/grpc/balancer/locality"
+	_ "github.com/apus-run/gaea/server/grpc/balancer/p2c"
+	"github.com/apus-run/gaea/server/grpc/balancer/pick"
+	_ "github.com/apus-run/gaea/server/grpc/balancer/wrr"
 	"github.com/apus-run/gaea/server/grpc/resolver/discovery"
 )
 
+// appInfo is the part of gaea.AppInfo used by the client, the client reads
+// it from the dial context.
+type appInfo interface {
+	ID() string
+	Metadata() map[string]string
+}
+
 // Client is gRPC Client
 type Client struct {
 	endpoint               string
@@ -28,6 +43,17 @@ type Client struct {
 	dialOpts               []grpc.DialOption
 	balancerName           string
 	printDiscoveryDebugLog bool
+	discoverySnapshot      string
+	discoveryFilters       []discovery.Filter
+	discoveryFallback      discovery.FallbackPolicy
+	subsetClientID         string
+	subsetSize             int
+	hedging                selector.Selector[HedgingPolicy]
+	hedgingObserver        HedgingObserver
+	timeouts               selector.Selector[time.Duration]
+	deadlineReserve        float64
+	locality               locality.Config
+	chash                  chash.Config
 }
 
 // defaultClient return a default config server
@@ -92,13 +118,47 @@ func WithStreamInterceptor(in ...grpc.StreamClientInterceptor) ClientOption {
 	}
 }
 
-// WithBalancerName with balancer name
+// WithBalancerName with balancer name, use wrr.Name to balance by the
+// "weight" metadata of the instances, p2c.Name to balance by the load, or
+// locality.Name to prefer the instances in the same zone and region, or
+// chash.Name to route the requests with the same key to the same instance.
 func WithBalancerName(name string) ClientOption {
 	return func(c *Client) {
 		c.balancerName = name
 	}
 }
 
+// WithLocality routes the requests to the instances whose "zone" and
+// "region" metadata match the given ones, it selects the locality balancer.
+// The zone and region default to the metadata of the Gaea application
+// carried by the dial context.
+func WithLocality(zone, region string) ClientOption {
+	return func(c *Client) {
+		c.balancerName = locality.Name
+		c.locality.Zone = zone
+		c.locality.Region = region
+	}
+}
+
+// WithLocalityFailover with the ratio of ready instances in a locality under
+// which the requests spill over to the other localities, defaults to
+// locality.DefaultFailoverRatio.
+func WithLocalityFailover(ratio float64) ClientOption {
+	return func(c *Client) {
+		c.locality.FailoverRatio = ratio
+	}
+}
+
+// WithHashKey routes the requests with the same value of the outgoing
+// metadata key to the same instance, it selects the consistent hash balancer.
+// The key can also be set per request with chash.WithKey.
+func WithHashKey(key string) ClientOption {
+	return func(c *Client) {
+		c.balancerName = chash.Name
+		c.chash.HashKey = key
+	}
+}
+
 // WithTimeout with client timeout.
 func WithTimeout(timeout time.Duration) ClientOption {
 	return func(c *Client) {
@@ -106,7 +166,33 @@ func WithTimeout(timeout time.Duration) ClientOption {
 	}
 }
 
+// WithMethodTimeout with the timeout of the methods matching the selector,
+// such as /helloworld.Greeter/SayHello for a method or /helloworld.Greeter/*
+// for a service, it overrides the client timeout. A zero timeout disables
+// the client timeout of the methods.
+func WithMethodTimeout(selector string, timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeouts.Add(selector, timeout)
+	}
+}
+
+// WithDeadlineReserve reserves the ratio, such as 0.1, of the remaining
+// deadline of the caller context, typically the deadline of the server
+// call being handled, for the local work after the downstream call.
+// The downstream call times out after the rest of the deadline at most.
+// A ratio outside [0, 1) is ignored, it would leave no time to the call.
+func WithDeadlineReserve(ratio float64) ClientOption {
+	return func(c *Client) {
+		if ratio < 0 || ratio >= 1 {
+			return
+		}
+		c.deadlineReserve = ratio
+	}
+}
+
 // WithDiscovery with client discovery.
+// Every connection watches the discovery on its own, wrap it with
+// cache.New to share one watch per service among the connections.
 func WithDiscovery(d registry.Discovery) ClientOption {
 	return func(c *Client) {
 		c.discovery = d
@@ -119,6 +205,58 @@ func WithPrintDiscoveryDebugLog(p bool) ClientOption {
 	}
 }
 
+// WithDiscoverySnapshot persists the instances resolved by the discovery in
+// dir, they are served on cold starts when the discovery is down.
+func WithDiscoverySnapshot(dir string) ClientOption {
+	return func(c *Client) {
+		c.discoverySnapshot = dir
+	}
+}
+
+// WithDiscoveryFilter with filters of the instances resolved by the discovery,
+// such as discovery.VersionFilter and discovery.MetadataFilter.
+func WithDiscoveryFilter(filters ...discovery.Filter) ClientOption {
+	return func(c *Client) {
+		c.discoveryFilters = filters
+	}
+}
+
+// WithDiscoveryFallback with the policy when the discovery filters reject
+// every instance, default is discovery.FallbackKeep.
+func WithDiscoveryFallback(p discovery.FallbackPolicy) ClientOption {
+	return func(c *Client) {
+		c.discoveryFallback = p
+	}
+}
+
+// WithSubset limits the connection to a deterministic subset of size
+// instances resolved by the discovery, the subset is chosen by the client
+// ID, which defaults to the ID of the Gaea application carried by the dial
+// context. Without either, a random ID of the process is used.
+func WithSubset(clientID string, size int) ClientOption {
+	return func(c *Client) {
+		c.subsetClientID = clientID
+		c.subsetSize = size
+	}
+}
+
+// WithHedging hedges the idempotent methods matching the selector, such as
+// /helloworld.Greeter/SayHello for a method or /helloworld.Greeter/* for a
+// service.
+func WithHedging(selector string, p HedgingPolicy) ClientOption {
+	return func(c *Client) {
+		c.hedging.Add(selector, p)
+	}
+}
+
+// WithHedgingObserver with the observer of the hedged calls, such as a
+// metrics counter of the attempts.
+func WithHedgingObserver(o HedgingObserver) ClientOption {
+	return func(c *Client) {
+		c.hedgingObserver = o
+	}
+}
+
 // Dial returns a GRPC connection.
 func Dial(ctx context.Context, opts ...ClientOption) (*grpc.ClientConn, error) {
 	return dial(ctx, false, opts...)
@@ -135,26 +273,48 @@ func dial(ctx context.Context, insecure bool, opts ...ClientOption) (*grpc.Clien
 		client.unaryClientInterceptor(client.ms, client.timeout),
 	}
 	sints := []grpc.StreamClientInterceptor{
-		client.streamClientInterceptor(),
+		client.streamClientInterceptor(client.ms),
 	}
 	if len(client.ints) > 0 {
 		ints = append(ints, client.ints...)
 	}
+	if client.hedging.Len() > 0 {
+		ints = append(ints, newHedger(client.hedging, client.hedgingObserver).unaryClientInterceptor())
+	}
 	if len(client.streamInts) > 0 {
 		sints = append(sints, client.streamInts...)
 	}
 	dialOpts := []grpc.DialOption{
-		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s":{}}],"healthCheckConfig":{"serviceName":""}}`, client.balancerName)),
+		grpc.WithDefaultServiceConfig(client.serviceConfig(ctx)),
 		grpc.WithChainUnaryInterceptor(ints...),
 		grpc.WithChainStreamInterceptor(sints...),
 	}
 	if client.discovery != nil {
+		discoveryOpts := []discovery.Option{
+			discovery.WithInsecure(insecure),
+			discovery.PrintDebugLog(client.printDiscoveryDebugLog),
+		}
+		if client.discoverySnapshot != "" {
+			discoveryOpts = append(discoveryOpts, discovery.WithSnapshot(client.discoverySnapshot))
+		}
+		if len(client.discoveryFilters) > 0 {
+			discoveryOpts = append(discoveryOpts,
+				discovery.WithFilter(client.discoveryFilters...),
+				discovery.WithFallback(client.discoveryFallback),
+			)
+		}
+		if client.subsetSize > 0 {
+			clientID := client.subsetClientID
+			if app, ok := ic.AppFromContext(ctx).(appInfo); ok && clientID == "" {
+				clientID = app.ID()
+			}
+			discoveryOpts = append(discoveryOpts, discovery.WithSubset(clientID, client.subsetSize))
+		}
 		dialOpts = append(dialOpts,
 			grpc.WithResolvers(
 				discovery.NewBuilder(
 					client.discovery,
-					discovery.WithInsecure(insecure),
-					discovery.PrintDebugLog(client.printDiscoveryDebugLog),
+					discoveryOpts...,
 				)))
 	}
 	if insecure {
@@ -169,3 +329,25 @@ func dial(ctx context.Context, insecure bool, opts ...ClientOption) (*grpc.Clien
 
 	return grpc.DialContext(ctx, client.endpoint, dialOpts...)
 }
+
+// serviceConfig returns the default service config selecting the balancer,
+// wrapped by pick balancer so that the middleware can check the backends.
+func (c *Client) serviceConfig(ctx context.Context) string {
+	lbConfig := "{}"
+	switch c.balancerName {
+	case locality.Name:
+		cfg := c.locality
+		if cfg.Zone == "" && cfg.Region == "" {
+			if app, ok := ic.AppFromContext(ctx).(appInfo); ok {
+				md := app.Metadata()
+				cfg.Zone, cfg.Region = md[locality.ZoneKey], md[locality.RegionKey]
+			}
+		}
+		b, _ := json.Marshal(cfg)
+		lbConfig = string(b)
+	case chash.Name:
+		b, _ := json.Marshal(c.chash)
+		lbConfig = string(b)
+	}
+	return fmt.Sprintf(`{"loadBalancingConfig": [{"%s":{"child":"%s","childConfig":%s}}],"healthCheckConfig":{"serviceName":""}}`, pick.Name, c.balancerName, lbConfig)
+}
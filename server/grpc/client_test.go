@@ -8,7 +8,9 @@ import (
 
 	"google.golang.org/grpc"
 
+	"github.com/apus-run/gaea"
 	"github.com/apus-run/gaea/middleware"
+	"github.com/apus-run/gaea/server/grpc/balancer/locality"
 )
 
 func EmptyMiddleware() middleware.Middleware {
@@ -74,3 +76,103 @@ func TestDialConn(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestClientServiceConfig(t *testing.T) {
+	c := ApplyClient()
+	if got, want := c.serviceConfig(context.Background()), `{"loadBalancingConfig": [{"gaea_pick":{"child":"round_robin","childConfig":{}}}],"healthCheckConfig":{"serviceName":""}}`; got != want {
+		t.Errorf("expect %s, got %s", want, got)
+	}
+
+	c = ApplyClient(WithLocality("a", "r1"), WithLocalityFailover(0.3))
+	if got, want := c.serviceConfig(context.Background()), `{"loadBalancingConfig": [{"gaea_pick":{"child":"gaea_locality","childConfig":{"zone":"a","region":"r1","failoverRatio":0.3}}}],"healthCheckConfig":{"serviceName":""}}`; got != want {
+		t.Errorf("expect %s, got %s", want, got)
+	}
+
+	// derived from the application metadata
+	app := gaea.New(gaea.WithMetadata(map[string]string{"zone": "b", "region": "r2"}))
+	ctx := gaea.NewContext(context.Background(), app)
+	c = ApplyClient(WithBalancerName(locality.Name))
+	if got, want := c.serviceConfig(ctx), `{"loadBalancingConfig": [{"gaea_pick":{"child":"gaea_locality","childConfig":{"zone":"b","region":"r2"}}}],"healthCheckConfig":{"serviceName":""}}`; got != want {
+		t.Errorf("expect %s, got %s", want, got)
+	}
+
+	c = ApplyClient(WithHashKey("x-user-id"))
+	if got, want := c.serviceConfig(context.Background()), `{"loadBalancingConfig": [{"gaea_pick":{"child":"gaea_consistent_hash","childConfig":{"hashKey":"x-user-id"}}}],"healthCheckConfig":{"serviceName":""}}`; got != want {
+		t.Errorf("expect %s, got %s", want, got)
+	}
+}
+
+func TestWithHedging(t *testing.T) {
+	c := ApplyClient(WithHedging("/helloworld.Greeter/*", HedgingPolicy{MaxAttempts: 2, Delay: time.Millisecond}))
+	if p, ok := c.hedging.Match("/helloworld.Greeter/SayHello"); !ok || p.MaxAttempts != 2 {
+		t.Errorf("expect the hedging policy, got %v %v", p, ok)
+	}
+}
+
+func TestClientCallTimeout(t *testing.T) {
+	c := ApplyClient(
+		WithMethodTimeout("/helloworld.Greeter/*", 5*time.Second),
+		WithMethodTimeout("/helloworld.Greeter/SayHelloStream", 0),
+	)
+	tests := []struct {
+		method string
+		want   time.Duration
+		ok     bool
+	}{
+		{"/helloworld.Greeter/SayHello", 5 * time.Second, true},
+		{"/helloworld.Greeter/SayHelloStream", 0, false},
+		{"/echo.Echo/Echo", 2 * time.Second, true},
+	}
+	for _, tt := range tests {
+		got, ok := c.callTimeout(context.Background(), tt.method, c.timeout)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: expect %v %v, got %v %v", tt.method, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestClientDeadlineReserve(t *testing.T) {
+	c := ApplyClient(WithTimeout(10*time.Second), WithDeadlineReserve(0.2))
+
+	// no deadline to reserve from
+	if got, _ := c.callTimeout(context.Background(), "/helloworld.Greeter/SayHello", c.timeout); got != 10*time.Second {
+		t.Errorf("expect 10s, got %v", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	got, ok := c.callTimeout(ctx, "/helloworld.Greeter/SayHello", c.timeout)
+	if !ok || got > 800*time.Millisecond || got < 700*time.Millisecond {
+		t.Errorf("expect about 800ms, got %v", got)
+	}
+
+	// a tighter timeout wins
+	c = ApplyClient(WithTimeout(100*time.Millisecond), WithDeadlineReserve(0.2))
+	if got, _ = c.callTimeout(ctx, "/helloworld.Greeter/SayHello", c.timeout); got != 100*time.Millisecond {
+		t.Errorf("expect 100ms, got %v", got)
+	}
+
+	// the deadline is applied by the interceptor
+	var remaining time.Duration
+	f := c.unaryClientInterceptor(nil, 0)
+	err := f(ctx, "/helloworld.Greeter/SayHello", nil, nil, &grpc.ClientConn{},
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			deadline, _ := ctx.Deadline()
+			remaining = time.Until(deadline)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining > 800*time.Millisecond || remaining < 700*time.Millisecond {
+		t.Errorf("expect about 800ms, got %v", remaining)
+	}
+
+	// invalid ratios are ignored
+	for _, ratio := range []float64{-0.5, 1, 2} {
+		c = ApplyClient(WithTimeout(10*time.Second), WithDeadlineReserve(0.2), WithDeadlineReserve(ratio))
+		if c.deadlineReserve != 0.2 {
+			t.Errorf("expect ratio %v ignored, got %v", ratio, c.deadlineReserve)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package grpc
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/apus-run/gaea/internal/selector"
+)
+
+// HedgingPolicy is the hedging policy of an idempotent method: when the
+// call has not answered within the delay, another attempt is sent, which
+// most balancers route to another backend. The first successful reply is
+// used and the other attempts are cancelled.
+type HedgingPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the
+	// original call.
+	MaxAttempts int
+	// Delay is the delay between attempts.
+	Delay time.Duration
+	// Percentile, such as 0.95, replaces Delay by the latency percentile
+	// of the method once enough calls have been observed.
+	Percentile float64
+}
+
+// HedgingObserver observes the hedged calls, attempts is the number of
+// attempts sent and winner the index of the attempt whose reply was used,
+// winner is -1 when all the attempts failed.
+type HedgingObserver func(method string, attempts, winner int)
+
+const (
+	// latencySamples is the number of latencies kept per method.
+	latencySamples = 1000
+	// minLatencySamples is the number of latencies needed before the
+	// percentile replaces the delay.
+	minLatencySamples = 20
+	// resortLatencies is the number of latencies added before the
+	// percentile is computed again.
+	resortLatencies = 100
+)
+
+type hedger struct {
+	policies selector.Selector[HedgingPolicy]
+	observer HedgingObserver
+
+	mu        sync.Mutex
+	latencies map[string]*latencies
+}
+
+func newHedger(policies selector.Selector[HedgingPolicy], observer HedgingObserver) *hedger {
+	return &hedger{
+		policies:  policies,
+		observer:  observer,
+		latencies: make(map[string]*latencies),
+	}
+}
+
+// unaryClientInterceptor hedges the unary calls of the methods with a
+// policy. Every attempt unmarshals into its own reply, the call options
+// are shared by the attempts, so the options writing to the caller, such
+// as grpc.Header, must not be used with hedging.
+func (h *hedger) unaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context,
+		method string,
+		req, reply any,
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption) error {
+
+		p, ok := h.policies.Match(method)
+		msg, isProto := reply.(proto.Message)
+		if !ok || !isProto || p.MaxAttempts < 2 {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		// cancels the attempts still in flight
+		defer cancel()
+
+		type result struct {
+			attempt int
+			reply   proto.Message
+			latency time.Duration
+			err     error
+		}
+		results := make(chan result, p.MaxAttempts)
+		send := func(attempt int) {
+			r := msg.ProtoReflect().New().Interface()
+			go func() {
+				start := time.Now()
+				err := invoker(ctx, method, req, r, cc, opts...)
+				results <- result{attempt: attempt, reply: r, latency: time.Since(start), err: err}
+			}()
+		}
+
+		delay := h.delay(method, p)
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		start := time.Now()
+		send(0)
+		sent, failed := 1, 0
+		// the percentile is computed from the latencies of the original
+		// attempts, the winners are biased towards the hedging delay
+		first := false
+		for {
+			select {
+			case <-timer.C:
+				send(sent)
+				sent++
+				if sent < p.MaxAttempts {
+					timer.Reset(delay)
+				}
+			case res := <-results:
+				if res.attempt == 0 {
+					first = true
+					if res.err == nil {
+						h.record(method, res.latency)
+					}
+				}
+				if res.err == nil {
+					if !first {
+						// the original attempt is cancelled, its latency is
+						// at least the elapsed time
+						h.record(method, time.Since(start))
+					}
+					proto.Reset(msg)
+					proto.Merge(msg, res.reply)
+					h.observe(method, sent, res.attempt)
+					return nil
+				}
+				failed++
+				if failed == sent {
+					h.observe(method, sent, -1)
+					return res.err
+				}
+			}
+		}
+	}
+}
+
+func (h *hedger) observe(method string, attempts, winner int) {
+	if h.observer != nil {
+		h.observer(method, attempts, winner)
+	}
+}
+
+// delay returns the hedging delay of the method.
+func (h *hedger) delay(method string, p HedgingPolicy) time.Duration {
+	if p.Percentile <= 0 {
+		return p.Delay
+	}
+	h.mu.Lock()
+	l, ok := h.latencies[method]
+	h.mu.Unlock()
+	if !ok {
+		return p.Delay
+	}
+	if d, ok := l.percentile(p.Percentile); ok {
+		return d
+	}
+	return p.Delay
+}
+
+func (h *hedger) record(method string, d time.Duration) {
+	h.mu.Lock()
+	l, ok := h.latencies[method]
+	if !ok {
+		l = &latencies{}
+		h.latencies[method] = l
+	}
+	h.mu.Unlock()
+	l.add(d)
+}
+
+// latencies keeps the last latencies of a method in a ring buffer.
+type latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	// sorted caches the sorted samples until resortLatencies are added
+	sorted []time.Duration
+	added  int
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) < latencySamples {
+		l.samples = append(l.samples, d)
+	} else {
+		l.samples[l.next] = d
+		l.next = (l.next + 1) % latencySamples
+	}
+	l.added++
+}
+
+func (l *latencies) percentile(p float64) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) < minLatencySamples {
+		return 0, false
+	}
+	if l.sorted == nil || l.added >= resortLatencies {
+		l.added = 0
+		l.sorted = make([]time.Duration, len(l.samples))
+		copy(l.sorted, l.samples)
+		sort.Slice(l.sorted, func(i, j int) bool {
+			return l.sorted[i] < l.sorted[j]
+		})
+	}
+	i := int(p * float64(len(l.sorted)))
+	if i >= len(l.sorted) {
+		i = len(l.sorted) - 1
+	}
+	return l.sorted[i], true
+}
@@ -0,0 +1,136 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/apus-run/gaea/internal/selector"
+	pb "github.com/apus-run/gaea/internal/testdata/helloworld"
+)
+
+const sayHello = "/helloworld.Greeter/SayHello"
+
+func testHedger(p HedgingPolicy, observer HedgingObserver) *hedger {
+	var policies selector.Selector[HedgingPolicy]
+	policies.Add("/helloworld.Greeter/*", p)
+	return newHedger(policies, observer)
+}
+
+func TestHedging(t *testing.T) {
+	var attempts, winner int
+	h := testHedger(HedgingPolicy{MaxAttempts: 3, Delay: 20 * time.Millisecond}, func(method string, a, w int) {
+		attempts, winner = a, w
+	})
+
+	var calls atomic.Int32
+	cancelled := make(chan struct{})
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		if calls.Add(1) == 1 {
+			// the first backend hangs until the hedge wins
+			<-ctx.Done()
+			close(cancelled)
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		reply.(*pb.HelloReply).Message = "hedged"
+		return nil
+	}
+
+	reply := &pb.HelloReply{}
+	start := time.Now()
+	err := h.unaryClientInterceptor()(context.Background(), sayHello, &pb.HelloRequest{}, reply, nil, invoker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Message != "hedged" {
+		t.Errorf("expect the hedged reply, got %q", reply.Message)
+	}
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Errorf("expect the hedge to answer fast, took %v", d)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expect the first attempt cancelled")
+	}
+	if attempts != 2 || winner != 1 {
+		t.Errorf("expect 2 attempts won by the hedge, got %d %d", attempts, winner)
+	}
+}
+
+func TestHedging_AllFailed(t *testing.T) {
+	var attempts, winner int
+	h := testHedger(HedgingPolicy{MaxAttempts: 3, Delay: time.Millisecond}, func(method string, a, w int) {
+		attempts, winner = a, w
+	})
+	errUnavailable := status.Error(codes.Unavailable, "down")
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		time.Sleep(10 * time.Millisecond)
+		return errUnavailable
+	}
+	err := h.unaryClientInterceptor()(context.Background(), sayHello, &pb.HelloRequest{}, &pb.HelloReply{}, nil, invoker)
+	if !errors.Is(err, errUnavailable) {
+		t.Fatalf("expect %v, got %v", errUnavailable, err)
+	}
+	if attempts != 3 || winner != -1 {
+		t.Errorf("expect 3 failed attempts, got %d %d", attempts, winner)
+	}
+}
+
+func TestHedging_NoPolicy(t *testing.T) {
+	h := testHedger(HedgingPolicy{MaxAttempts: 3, Delay: time.Millisecond}, nil)
+	var calls atomic.Int32
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	if err := h.unaryClientInterceptor()(context.Background(), "/echo.Echo/Echo", &pb.HelloRequest{}, &pb.HelloReply{}, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expect 1 call, got %d", calls.Load())
+	}
+}
+
+func TestHedging_Percentile(t *testing.T) {
+	h := testHedger(HedgingPolicy{MaxAttempts: 2, Delay: time.Second, Percentile: 0.9}, nil)
+	p, _ := h.policies.Match(sayHello)
+	if d := h.delay(sayHello, p); d != time.Second {
+		t.Errorf("expect the delay without samples, got %v", d)
+	}
+	for i := 1; i <= 100; i++ {
+		h.record(sayHello, time.Duration(i)*time.Millisecond)
+	}
+	if d := h.delay(sayHello, p); d != 91*time.Millisecond {
+		t.Errorf("expect p90 91ms, got %v", d)
+	}
+}
+
+func TestHedging_RecordFirstAttempt(t *testing.T) {
+	h := testHedger(HedgingPolicy{MaxAttempts: 2, Delay: 10 * time.Millisecond, Percentile: 0.9}, nil)
+	var calls atomic.Int32
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		if calls.Add(1) == 1 {
+			<-ctx.Done()
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		return nil
+	}
+	err := h.unaryClientInterceptor()(context.Background(), sayHello, &pb.HelloRequest{}, &pb.HelloReply{}, nil, invoker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the hedge answers at once, the original attempt took at least the
+	// delay before it was cancelled
+	samples := h.latencies[sayHello].samples
+	if len(samples) != 1 || samples[0] < 10*time.Millisecond {
+		t.Errorf("expect the latency of the original attempt, got %v", samples)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	ic "github.com/apus-run/gaea/internal/context"
 	"github.com/apus-run/gaea/middleware"
@@ -15,6 +17,8 @@ import (
 type wrappedStream struct {
 	grpc.ServerStream
 	ctx context.Context
+	// stream is the stream wrapped by the middleware, if any
+	stream middleware.Stream
 }
 
 func NewWrappedStream(ctx context.Context, stream grpc.ServerStream) grpc.ServerStream {
@@ -28,11 +32,27 @@ func (w *wrappedStream) Context() context.Context {
 	return w.ctx
 }
 
+func (w *wrappedStream) SendMsg(m any) error {
+	if w.stream != nil {
+		return w.stream.SendMsg(m)
+	}
+	return w.ServerStream.SendMsg(m)
+}
+
+func (w *wrappedStream) RecvMsg(m any) error {
+	if w.stream != nil {
+		return w.stream.RecvMsg(m)
+	}
+	return w.ServerStream.RecvMsg(m)
+}
+
 // unaryServerInterceptor is a gRPC unary server interceptor
 func (s *Server) unaryServerInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
 		ctx, cancel := ic.Merge(ctx, s.ctx)
 		defer cancel()
+		ctx, cancelTimeout := s.withTimeout(ctx, info.FullMethod, false)
+		defer cancelTimeout()
 		md, ok := metadata.FromIncomingContext(ctx)
 		if ok {
 			md = md.Copy()
@@ -46,10 +66,14 @@ func (s *Server) unaryServerInterceptor() grpc.UnaryServerInterceptor {
 			h = middleware.Chain(next...)(h)
 		}
 
+		ctx = middleware.NewOperationContext(ctx, info.FullMethod)
 		reply, err := h(ctx, req)
 		if len(md) > 0 {
 			_ = grpc.SetHeader(ctx, md)
 		}
+		if deadlineErr := deadlineExceeded(ctx); deadlineErr != nil {
+			return nil, deadlineErr
+		}
 		return reply, err
 	}
 }
@@ -59,22 +83,69 @@ func (s *Server) streamServerInterceptor() grpc.StreamServerInterceptor {
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 		ctx, cancel := ic.Merge(ss.Context(), s.ctx)
 		defer cancel()
+		ctx, cancelTimeout := s.withTimeout(ctx, info.FullMethod, true)
+		defer cancelTimeout()
 		md, ok := metadata.FromIncomingContext(ctx)
 		if ok {
 			md = md.Copy()
 		} else {
 			md = metadata.MD{}
 		}
-		ws := NewWrappedStream(ctx, ss)
+		h := func(ctx context.Context, _ any) (any, error) {
+			ws := &wrappedStream{ServerStream: ss, ctx: ctx}
+			if stream, ok := middleware.StreamFromContext(ctx); ok && stream != ss {
+				ws.stream = stream
+			}
+			return nil, handler(srv, ws)
+		}
+		if next := s.middleware.Match(info.FullMethod); len(next) > 0 {
+			h = middleware.Chain(next...)(h)
+		}
 
-		err := handler(srv, ws)
+		ctx = middleware.NewOperationContext(ctx, info.FullMethod)
+		ctx = middleware.NewStreamContext(ctx, ss)
+		_, err := h(ctx, nil)
 		if len(md) > 0 {
 			_ = grpc.SetHeader(ctx, md)
 		}
+		if deadlineErr := deadlineExceeded(ctx); deadlineErr != nil {
+			return deadlineErr
+		}
 		return err
 	}
 }
 
+// withTimeout applies the timeout of the method to ctx when the client sent
+// no deadline. The streams are often long-lived, so they only get the
+// timeout of their method, there is none for the streams of the gRPC
+// services, such as the health watch, unless set by MethodTimeout.
+func (s *Server) withTimeout(ctx context.Context, method string, stream bool) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	timeout := s.timeout
+	if stream {
+		timeout = 0
+	}
+	if t, ok := s.timeouts.Match(method); ok {
+		timeout = t
+	}
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// deadlineExceeded returns a DeadlineExceeded status once the deadline of
+// the call has expired, whatever the handler returned, so that the handlers
+// returning ctx.Err() or ignoring ctx are reported the same way.
+func deadlineExceeded(ctx context.Context) error {
+	if ctx.Err() != context.DeadlineExceeded {
+		return nil
+	}
+	return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+}
+
 // unaryClientInterceptor client unary interceptor
 func (c *Client) unaryClientInterceptor(ms []middleware.Middleware, timeout time.Duration) grpc.UnaryClientInterceptor {
 	return func(ctx context.Context,
@@ -84,9 +155,9 @@ func (c *Client) unaryClientInterceptor(ms []middleware.Middleware, timeout time
 		invoker grpc.UnaryInvoker,
 		opts ...grpc.CallOption) error {
 
-		if timeout > 0 {
+		if t, ok := c.callTimeout(ctx, method, timeout); ok {
 			var cancel context.CancelFunc
-			ctx, cancel = context.WithTimeout(ctx, timeout)
+			ctx, cancel = context.WithTimeout(ctx, t)
 			defer cancel()
 		}
 
@@ -98,13 +169,47 @@ func (c *Client) unaryClientInterceptor(ms []middleware.Middleware, timeout time
 			h = middleware.Chain(ms...)(h)
 		}
 
+		ctx = middleware.NewOperationContext(ctx, method)
+		ctx = middleware.NewTargetContext(ctx, cc.Target())
+		// a call made from a stream handler is not part of the stream
+		ctx = middleware.NewStreamContext(ctx, nil)
 		_, err := h(ctx, req)
 
 		return err
 	}
 }
 
-func (c *Client) streamClientInterceptor() grpc.StreamClientInterceptor {
+// callTimeout returns the timeout of the call to method and whether the
+// call needs a timeout at all, the deadline of ctx applies in any case.
+// The timeout of the method overrides the default one, and the deadline
+// reserve shortens it to a fraction of the deadline of ctx.
+func (c *Client) callTimeout(ctx context.Context, method string, timeout time.Duration) (time.Duration, bool) {
+	if t, ok := c.timeouts.Match(method); ok {
+		timeout = t
+	}
+	deadline, ok := ctx.Deadline()
+	if c.deadlineReserve <= 0 || !ok {
+		return timeout, timeout > 0
+	}
+	// a budget already spent expires the call immediately
+	budget := time.Duration(float64(time.Until(deadline)) * (1 - c.deadlineReserve))
+	if timeout <= 0 || budget < timeout {
+		return budget, true
+	}
+	return timeout, true
+}
+
+// errStreamOpened is returned when a middleware calls the handler of a
+// stream again, such as to retry it: a stream is opened at most once.
+var errStreamOpened = status.Error(codes.Internal, "stream is already opened")
+
+// streamClientInterceptor runs the client middleware around every stream,
+// from its opening to its end. The middleware chain runs in its own
+// goroutine, which lives as long as the stream, so the caller must end the
+// stream as with any gRPC stream, by cancelling the context or receiving
+// until an error. The handler opens the stream on its first call only, the
+// later calls fail with errStreamOpened.
+func (c *Client) streamClientInterceptor(ms []middleware.Middleware) grpc.StreamClientInterceptor {
 	return func(ctx context.Context,
 		desc *grpc.StreamDesc,
 		cc *grpc.ClientConn,
@@ -112,6 +217,86 @@ func (c *Client) streamClientInterceptor() grpc.StreamClientInterceptor {
 		streamer grpc.Streamer,
 		opts ...grpc.CallOption) (grpc.ClientStream, error) { // nolint
 
-		return streamer(ctx, desc, cc, method, opts...)
+		if len(ms) == 0 {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		type result struct {
+			cs  grpc.ClientStream
+			err error
+		}
+		var (
+			opened  = make(chan result, 1)
+			started bool
+		)
+		// the bottom of the middleware streams, it is bound to the gRPC
+		// stream once opened
+		bottom := &clientStream{ctx: ctx}
+		h := func(ctx context.Context, _ any) (any, error) {
+			if started {
+				return nil, errStreamOpened
+			}
+			started = true
+			done := make(chan error, 1)
+			cs, err := streamer(ctx, desc, cc, method, append(opts, grpc.OnFinish(func(err error) {
+				done <- err
+			}))...)
+			if err != nil {
+				opened <- result{err: err}
+				return nil, err
+			}
+			bottom.ClientStream = cs
+			s, _ := middleware.StreamFromContext(ctx)
+			opened <- result{cs: &clientStream{ClientStream: cs, ctx: ctx, stream: s}}
+			return nil, <-done
+		}
+		h = middleware.Chain(ms...)(h)
+
+		ctx = middleware.NewOperationContext(ctx, method)
+		ctx = middleware.NewTargetContext(ctx, cc.Target())
+		ctx = middleware.NewStreamContext(ctx, bottom)
+		go func() {
+			_, err := h(ctx, nil)
+			if started {
+				return
+			}
+			// a middleware rejected the stream
+			if err == nil {
+				err = status.Error(codes.Internal, "middleware did not open the stream")
+			}
+			opened <- result{err: err}
+		}()
+		res := <-opened
+		return res.cs, res.err
+	}
+}
+
+// clientStream routes the messages of a client stream through the stream
+// wrapped by the middleware.
+type clientStream struct {
+	grpc.ClientStream
+	ctx context.Context
+	// stream is the stream wrapped by the middleware, if any
+	stream middleware.Stream
+}
+
+func (s *clientStream) Context() context.Context {
+	if s.ClientStream != nil {
+		return s.ClientStream.Context()
+	}
+	return s.ctx
+}
+
+func (s *clientStream) SendMsg(m any) error {
+	if s.stream != nil {
+		return s.stream.SendMsg(m)
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *clientStream) RecvMsg(m any) error {
+	if s.stream != nil {
+		return s.stream.RecvMsg(m)
 	}
+	return s.ClientStream.RecvMsg(m)
 }
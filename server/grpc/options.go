@@ -5,12 +5,14 @@ import (
 	"crypto/tls"
 	"net"
 	"net/url"
+	"sync"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health"
 
 	"github.com/apus-run/gaea/internal/matcher"
+	"github.com/apus-run/gaea/internal/selector"
 	"github.com/apus-run/gaea/middleware"
 )
 
@@ -32,6 +34,9 @@ type Server struct {
 
 	customHealth bool
 	adminClean   func()
+	ready        chan struct{}
+	readyOnce    sync.Once
+	timeouts     selector.Selector[time.Duration]
 }
 
 // defaultServer return a default config server
@@ -43,6 +48,7 @@ func defaultServer() *Server {
 		timeout:    1 * time.Second,
 		health:     health.NewServer(),
 		middleware: matcher.New(),
+		ready:      make(chan struct{}),
 	}
 }
 
@@ -78,13 +84,28 @@ func Endpoint(endpoint *url.URL) ServerOption {
 	}
 }
 
-// Timeout with server timeout.
+// Timeout with server timeout, the default deadline of the unary calls
+// whose client sent no deadline. A zero timeout disables it.
+// Unlike the client timeout, it does not apply to the streams, which are
+// often long-lived, use MethodTimeout to give them a deadline.
 func Timeout(timeout time.Duration) ServerOption {
 	return func(s *Server) {
 		s.timeout = timeout
 	}
 }
 
+// MethodTimeout with the timeout of the methods matching the selector, it
+// overrides the server timeout, see Server.Use for the selector syntax.
+// Unlike the server timeout, it applies to the streaming methods too,
+// including the streams of the gRPC services, such as the health watch,
+// which never time out otherwise. Add MethodTimeout("/grpc.*", 0) to keep
+// them open when a catch-all selector such as "/*" is used.
+func MethodTimeout(selector string, timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.timeouts.Add(selector, timeout)
+	}
+}
+
 // Middleware with server middleware.
 func Middleware(m ...middleware.Middleware) ServerOption {
 	return func(s *Server) {
@@ -10,8 +10,10 @@ import (
 
 	"google.golang.org/grpc"
 
+	"github.com/apus-run/gaea/internal/matcher"
 	"github.com/apus-run/gaea/middleware"
 	"github.com/apus-run/gaea/registry"
+	"github.com/apus-run/gaea/server/grpc/resolver/discovery"
 )
 
 func TestNetwork(t *testing.T) {
@@ -39,13 +41,13 @@ func TestAddress(t *testing.T) {
 }
 
 func TestMiddleware(t *testing.T) {
-	o := &Server{}
+	o := &Server{middleware: matcher.New()}
 	v := []middleware.Middleware{
 		func(middleware.Handler) middleware.Handler { return nil },
 	}
 	Middleware(v...)(o)
-	if !reflect.DeepEqual(v, o.middleware) {
-		t.Errorf("expect %v, got %v", v, o.middleware)
+	if got := o.middleware.Match("/foo/bar"); len(got) != len(v) {
+		t.Errorf("expect %v, got %v", v, got)
 	}
 }
 
@@ -154,6 +156,33 @@ func TestWithDiscovery(t *testing.T) {
 	}
 }
 
+func TestWithDiscoverySnapshot(t *testing.T) {
+	o := &Client{}
+	v := "/tmp/snapshot"
+	WithDiscoverySnapshot(v)(o)
+	if !reflect.DeepEqual(v, o.discoverySnapshot) {
+		t.Errorf("expect %v but got %v", v, o.discoverySnapshot)
+	}
+}
+
+func TestWithDiscoveryFilter(t *testing.T) {
+	o := &Client{}
+	v := discovery.MetadataFilter(map[string]string{"env": "staging"})
+	WithDiscoveryFilter(v, v)(o)
+	if len(o.discoveryFilters) != 2 {
+		t.Errorf("expect 2 filters but got %d", len(o.discoveryFilters))
+	}
+}
+
+func TestWithDiscoveryFallback(t *testing.T) {
+	o := &Client{}
+	v := discovery.FallbackAll
+	WithDiscoveryFallback(v)(o)
+	if !reflect.DeepEqual(v, o.discoveryFallback) {
+		t.Errorf("expect %v but got %v", v, o.discoveryFallback)
+	}
+}
+
 func TestWithTLSConfig(t *testing.T) {
 	o := &Client{}
 	v := &tls.Config{}
@@ -169,8 +198,8 @@ func TestWithOptions(t *testing.T) {
 		grpc.EmptyDialOption{},
 	}
 	WithDialOptions(v...)(o)
-	if !reflect.DeepEqual(v, o.grpcOpts) {
-		t.Errorf("expect %v but got %v", v, o.grpcOpts)
+	if !reflect.DeepEqual(v, o.dialOpts) {
+		t.Errorf("expect %v but got %v", v, o.dialOpts)
 	}
 }
 
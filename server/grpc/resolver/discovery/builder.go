@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	log "google.golang.org/grpc/grpclog"
 	"google.golang.org/grpc/resolver"
 
 	"github.com/apus-run/gaea/registry"
@@ -37,18 +38,62 @@ func DisableDebugLog() Option {
 	}
 }
 
-// PrintDebugLog print grpc resolver watch service log
+// PrintDebugLog print grpc resolver watch service log, default is true.
+// The log is written at the info level of grpclog.
 func PrintDebugLog(p bool) Option {
 	return func(b *builder) {
 		b.debugLog = p
 	}
 }
 
+// WithSnapshot persists the last resolved instances of every service in dir.
+// When a snapshot exists, the resolver serves it as soon as it is built,
+// without waiting for the discovery, and replaces it once the watcher
+// delivers live instances. It keeps a cold start working while the
+// discovery is down.
+func WithSnapshot(dir string) Option {
+	return func(b *builder) {
+		b.snapshot = &snapshot{dir: dir}
+	}
+}
+
+// WithFilter with filters of the instances, an instance is used only if
+// all the filters accept it.
+func WithFilter(filters ...Filter) Option {
+	return func(b *builder) {
+		b.filters = append(b.filters, filters...)
+	}
+}
+
+// WithFallback with the policy when the filters reject every instance,
+// default is FallbackKeep.
+func WithFallback(p FallbackPolicy) Option {
+	return func(b *builder) {
+		b.fallback = p
+	}
+}
+
+// WithSubset limits the resolver to a deterministic subset of size instances
+// chosen by the client ID, such as the ID of the Gaea application, instead
+// of connecting to every instance. The subsets are chosen by rendezvous
+// hashing, so the clients spread over the instances evenly on average, and
+// an instance joining or leaving changes a subset by that instance only.
+// An empty client ID falls back to a random ID of the process.
+func WithSubset(clientID string, size int) Option {
+	return func(b *builder) {
+		b.subset = newSubset(clientID, size)
+	}
+}
+
 type builder struct {
 	discoverer registry.Discovery
 	timeout    time.Duration
 	insecure   bool
 	debugLog   bool
+	snapshot   *snapshot
+	filters    []Filter
+	fallback   FallbackPolicy
+	subset     *subset
 }
 
 // NewBuilder creates a builder which is used to factory registry resolvers.
@@ -71,15 +116,50 @@ func (b *builder) Build(target resolver.Target, cc resolver.ClientConn, opts res
 		w   registry.Watcher
 	}{}
 
+	serviceName := strings.TrimPrefix(target.URL.Path, "/")
 	done := make(chan struct{}, 1)
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
-		w, err := b.discoverer.Watch(ctx, strings.TrimPrefix(target.URL.Path, "/"))
+		w, err := b.discoverer.Watch(ctx, serviceName)
 		watchRes.w = w
 		watchRes.err = err
 		close(done)
 	}()
 
+	r := &discoveryResolver{
+		cc:          cc,
+		ctx:         ctx,
+		cancel:      cancel,
+		insecure:    b.insecure,
+		debugLog:    b.debugLog,
+		serviceName: serviceName,
+		snapshot:    b.snapshot,
+		filters:     b.filters,
+		fallback:    b.fallback,
+		subset:      b.subset,
+		discoverer:  b.discoverer,
+		timeout:     b.timeout,
+		resolveNow:  make(chan struct{}, 1),
+	}
+
+	// serve the snapshot right away instead of waiting for the discovery,
+	// the first instances delivered by the watcher replace it
+	if r.serveSnapshot() {
+		go r.refresh()
+		go func() {
+			<-done
+			w, err := watchRes.w, watchRes.err
+			if err != nil {
+				log.Warningf("[resolver] serve snapshot of %s, the discovery is unavailable: %v", serviceName, err)
+				w = r.rewatch(b.discoverer)
+			}
+			if r.setWatcher(w) {
+				r.watch()
+			}
+		}()
+		return r, nil
+	}
+
 	var err error
 	select {
 	case <-done:
@@ -91,15 +171,9 @@ func (b *builder) Build(target resolver.Target, cc resolver.ClientConn, opts res
 		cancel()
 		return nil, err
 	}
-	r := &discoveryResolver{
-		w:        watchRes.w,
-		cc:       cc,
-		ctx:      ctx,
-		cancel:   cancel,
-		insecure: b.insecure,
-		debugLog: b.debugLog,
-	}
+	r.w = watchRes.w
 	go r.watch()
+	go r.refresh()
 	return r, nil
 }
 
@@ -30,10 +30,10 @@ func TestWithTimeout(t *testing.T) {
 }
 
 func TestDisableDebugLog(t *testing.T) {
-	o := &builder{}
+	o := &builder{debugLog: true}
 	DisableDebugLog()(o)
-	if !o.debugLog {
-		t.Errorf("expected debugLogDisabled true, got %v", o.debugLog)
+	if o.debugLog {
+		t.Errorf("expected debugLog false, got %v", o.debugLog)
 	}
 }
 
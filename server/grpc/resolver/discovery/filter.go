@@ -0,0 +1,67 @@
+package discovery
+
+import (
+	"github.com/apus-run/gaea/internal/semver"
+	"github.com/apus-run/gaea/registry"
+)
+
+// Filter reports whether the resolver should use the service instance.
+type Filter func(in *registry.ServiceInstance) bool
+
+// FallbackPolicy decides what the resolver does when the filters reject
+// every instance.
+type FallbackPolicy int
+
+const (
+	// FallbackKeep keeps the addresses resolved before, it is the default.
+	FallbackKeep FallbackPolicy = iota
+	// FallbackAll uses all the instances as if there was no filter.
+	FallbackAll
+)
+
+// VersionFilter returns a Filter of the instances whose version satisfies
+// the semver constraint, such as ">=1.2.0 <2.0.0" or "^1.2".
+func VersionFilter(constraint string) (Filter, error) {
+	c, err := semver.ParseConstraint(constraint)
+	if err != nil {
+		return nil, err
+	}
+	return func(in *registry.ServiceInstance) bool {
+		v, err := semver.Parse(in.Version)
+		if err != nil {
+			return false
+		}
+		return c.Check(v)
+	}, nil
+}
+
+// MetadataFilter returns a Filter of the instances whose metadata contains
+// all the selectors, such as env=staging and cluster=blue.
+func MetadataFilter(selectors map[string]string) Filter {
+	return func(in *registry.ServiceInstance) bool {
+		for k, v := range selectors {
+			if mv, ok := in.Metadata[k]; !ok || mv != v {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// filter returns the instances accepted by all the filters.
+func filter(ins []*registry.ServiceInstance, filters []Filter) []*registry.ServiceInstance {
+	if len(filters) == 0 {
+		return ins
+	}
+	filtered := make([]*registry.ServiceInstance, 0, len(ins))
+next:
+	for _, in := range ins {
+		for _, f := range filters {
+			if !f(in) {
+				continue next
+			}
+		}
+		filtered = append(filtered, in)
+	}
+	return filtered
+}
@@ -0,0 +1,75 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/apus-run/gaea/registry"
+)
+
+func testInstances() []*registry.ServiceInstance {
+	return []*registry.ServiceInstance{
+		{ID: "1", Version: "v1.2.0", Metadata: map[string]string{"env": "staging", "cluster": "blue"}, Endpoints: []string{"grpc://127.0.0.1:9001"}},
+		{ID: "2", Version: "v1.5.3", Metadata: map[string]string{"env": "prod", "cluster": "blue"}, Endpoints: []string{"grpc://127.0.0.1:9002"}},
+		{ID: "3", Version: "v2.0.0", Metadata: map[string]string{"env": "staging", "cluster": "green"}, Endpoints: []string{"grpc://127.0.0.1:9003"}},
+		{ID: "4", Version: "unknown", Endpoints: []string{"grpc://127.0.0.1:9004"}},
+	}
+}
+
+func ids(ins []*registry.ServiceInstance) []string {
+	ids := make([]string, 0, len(ins))
+	for _, in := range ins {
+		ids = append(ids, in.ID)
+	}
+	return ids
+}
+
+func TestVersionFilter(t *testing.T) {
+	f, err := VersionFilter(">=1.2.0 <2.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ids(filter(testInstances(), []Filter{f}))
+	if len(got) != 2 || got[0] != "1" || got[1] != "2" {
+		t.Errorf("expect [1 2], got %v", got)
+	}
+	if _, err = VersionFilter(">=a"); err == nil {
+		t.Error("expect invalid constraint error")
+	}
+}
+
+func TestMetadataFilter(t *testing.T) {
+	f := MetadataFilter(map[string]string{"env": "staging", "cluster": "blue"})
+	got := ids(filter(testInstances(), []Filter{f}))
+	if len(got) != 1 || got[0] != "1" {
+		t.Errorf("expect [1], got %v", got)
+	}
+
+	v, _ := VersionFilter("^2")
+	got = ids(filter(testInstances(), []Filter{MetadataFilter(map[string]string{"env": "staging"}), v}))
+	if len(got) != 1 || got[0] != "3" {
+		t.Errorf("expect [3], got %v", got)
+	}
+}
+
+func TestResolverFallback(t *testing.T) {
+	none := MetadataFilter(map[string]string{"env": "dev"})
+
+	cc := &stateConn{}
+	r := &discoveryResolver{cc: cc, insecure: true, filters: []Filter{none}}
+	if r.updateState(testInstances()) {
+		t.Error("expect the resolved addresses kept")
+	}
+	if _, n := cc.last(); n != 0 {
+		t.Errorf("expect no update, got %d", n)
+	}
+
+	r.fallback = FallbackAll
+	if !r.updateState(testInstances()) {
+		t.Error("expect fall back to all instances")
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if n := len(cc.states[0].Addresses); n != 4 {
+		t.Errorf("expect 4 addresses, got %d", n)
+	}
+}
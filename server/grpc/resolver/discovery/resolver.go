@@ -4,17 +4,36 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"sync"
 	"time"
 
 	"google.golang.org/grpc/attributes"
+	log "google.golang.org/grpc/grpclog"
 	"google.golang.org/grpc/resolver"
 
+	"github.com/apus-run/gaea/internal/backoff"
 	"github.com/apus-run/gaea/internal/endpoint"
 	"github.com/apus-run/gaea/registry"
 )
 
+const (
+	// reportErrorAfter is the number of consecutive watch failures after
+	// which they are reported to the client conn.
+	reportErrorAfter = 3
+	// minResolveInterval rate limits the refreshes triggered by ResolveNow.
+	minResolveInterval = time.Second
+)
+
+// watchBackoff is the backoff of retrying a failed watch.
+var watchBackoff = backoff.Exponential{
+	BaseDelay:  500 * time.Millisecond,
+	Multiplier: 1.6,
+	Jitter:     0.2,
+	MaxDelay:   30 * time.Second,
+}
+
 type discoveryResolver struct {
+	mu sync.Mutex
 	w  registry.Watcher
 	cc resolver.ClientConn
 
@@ -23,9 +42,69 @@ type discoveryResolver struct {
 
 	insecure bool
 	debugLog bool
+
+	serviceName string
+	snapshot    *snapshot
+	filters     []Filter
+	fallback    FallbackPolicy
+	subset      *subset
+
+	// discoverer and resolveNow serve ResolveNow
+	discoverer registry.Discovery
+	timeout    time.Duration
+	resolveNow chan struct{}
+}
+
+// serveSnapshot updates the client conn with the snapshot of the service,
+// it reports whether there was a snapshot to serve.
+func (r *discoveryResolver) serveSnapshot() bool {
+	if r.snapshot == nil {
+		return false
+	}
+	ins, err := r.snapshot.load(r.serviceName)
+	if err != nil {
+		log.Errorf("[resolver] failed to load snapshot of %s: %v", r.serviceName, err)
+		return false
+	}
+	if len(ins) == 0 {
+		return false
+	}
+	return r.updateState(ins)
+}
+
+// rewatch creates the watcher with backoff until it succeeds, it returns
+// nil once the resolver is closed.
+func (r *discoveryResolver) rewatch(d registry.Discovery) registry.Watcher {
+	for retries := 0; ; retries++ {
+		if !r.sleep(watchBackoff.Backoff(retries)) {
+			return nil
+		}
+		w, err := d.Watch(r.ctx, r.serviceName)
+		if err == nil {
+			return w
+		}
+		log.Warningf("[resolver] failed to watch %s, retries %d: %v", r.serviceName, retries, err)
+	}
+}
+
+// setWatcher sets the watcher created after Build, it reports false and
+// stops the watcher if the resolver has been closed meanwhile.
+func (r *discoveryResolver) setWatcher(w registry.Watcher) bool {
+	if w == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ctx.Err() != nil {
+		_ = w.Stop()
+		return false
+	}
+	r.w = w
+	return true
 }
 
 func (r *discoveryResolver) watch() {
+	failures := 0
 	for {
 		select {
 		case <-r.ctx.Done():
@@ -34,24 +113,91 @@ func (r *discoveryResolver) watch() {
 		}
 		ins, err := r.w.Next()
 		if err != nil {
-			if errors.Is(err, context.Canceled) {
+			if errors.Is(err, context.Canceled) || r.ctx.Err() != nil {
+				return
+			}
+			failures++
+			log.Errorf("[resolver] failed to watch %s, failures %d: %v", r.serviceName, failures, err)
+			if failures >= reportErrorAfter {
+				r.cc.ReportError(err)
+			}
+			if !r.sleep(watchBackoff.Backoff(failures - 1)) {
 				return
 			}
-			fmt.Errorf("[resolver] Failed to watch discovery endpoint: %v", err)
-			time.Sleep(time.Second)
 			continue
 		}
+		failures = 0
 		r.update(ins)
 	}
 }
 
+// refresh gets the instances from the discovery on ResolveNow.
+func (r *discoveryResolver) refresh() {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-r.resolveNow:
+		}
+		ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+		ins, err := r.discoverer.GetService(ctx, r.serviceName)
+		cancel()
+		if err != nil {
+			if r.ctx.Err() != nil {
+				return
+			}
+			log.Warningf("[resolver] failed to resolve %s now: %v", r.serviceName, err)
+		} else {
+			r.update(ins)
+		}
+		if !r.sleep(minResolveInterval) {
+			return
+		}
+	}
+}
+
+// sleep waits for d, it reports false if the resolver is closed meanwhile.
+func (r *discoveryResolver) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-r.ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
 func (r *discoveryResolver) update(ins []*registry.ServiceInstance) {
+	if !r.updateState(ins) || r.snapshot == nil {
+		return
+	}
+	if err := r.snapshot.save(r.serviceName, ins); err != nil {
+		log.Errorf("[resolver] failed to save snapshot of %s: %v", r.serviceName, err)
+	}
+}
+
+// updateState updates the client conn with the addresses of ins, it reports
+// whether the state was updated.
+func (r *discoveryResolver) updateState(ins []*registry.ServiceInstance) bool {
+	if filtered := filter(ins, r.filters); len(filtered) < len(ins) {
+		if len(filtered) == 0 && len(ins) > 0 {
+			if r.fallback != FallbackAll {
+				log.Warningf("[resolver] no instance of %s passes the filters, keep the resolved addresses", r.serviceName)
+				return false
+			}
+			log.Warningf("[resolver] no instance of %s passes the filters, fall back to all instances", r.serviceName)
+		} else {
+			ins = filtered
+		}
+	}
+	ins = r.subset.apply(ins)
 	addrs := make([]resolver.Address, 0)
 	endpoints := make(map[string]struct{})
 	for _, in := range ins {
 		ept, err := endpoint.ParseEndpoint(in.Endpoints, endpoint.Scheme("grpc", !r.insecure))
 		if err != nil {
-			fmt.Errorf("[resolver] Failed to parse discovery endpoint: %v", err)
+			log.Errorf("[resolver] failed to parse discovery endpoint of %s instance %s: %v", r.serviceName, in.ID, err)
 			continue
 		}
 		if ept == "" {
@@ -71,29 +217,43 @@ func (r *discoveryResolver) update(ins []*registry.ServiceInstance) {
 		addrs = append(addrs, addr)
 	}
 	if len(addrs) == 0 {
-		fmt.Printf("[resolver] Zero endpoint found,refused to write, instances: %v", ins)
-		return
+		log.Warningf("[resolver] zero endpoint of %s found, refused to write, instances: %d", r.serviceName, len(ins))
+		return false
 	}
 	err := r.cc.UpdateState(resolver.State{Addresses: addrs})
 	if err != nil {
-		fmt.Errorf("[resolver] failed to update state: %s", err)
+		log.Errorf("[resolver] failed to update state of %s: %v", r.serviceName, err)
+		return false
 	}
 
-	if !r.debugLog {
+	if r.debugLog {
 		b, _ := json.Marshal(ins)
-		fmt.Printf("[resolver] update instances: %s", b)
+		log.Infof("[resolver] update instances of %s: %s", r.serviceName, b)
 	}
+	return true
 }
 
 func (r *discoveryResolver) Close() {
+	r.mu.Lock()
 	r.cancel()
-	err := r.w.Stop()
+	w := r.w
+	r.mu.Unlock()
+	if w == nil {
+		return
+	}
+	err := w.Stop()
 	if err != nil {
-		fmt.Errorf("[resolver] failed to watch top: %s", err)
+		log.Errorf("[resolver] failed to stop watching %s: %v", r.serviceName, err)
 	}
 }
 
-func (r *discoveryResolver) ResolveNow(_ resolver.ResolveNowOptions) {}
+// ResolveNow triggers an immediate refresh from the discovery.
+func (r *discoveryResolver) ResolveNow(_ resolver.ResolveNowOptions) {
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
 
 func parseAttributes(md map[string]string) *attributes.Attributes {
 	var a *attributes.Attributes
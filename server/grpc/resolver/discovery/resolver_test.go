@@ -4,12 +4,14 @@ import (
 	"context"
 	"errors"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"google.golang.org/grpc/resolver"
 
 	"github.com/apus-run/gaea/registry"
+	"github.com/apus-run/gaea/registry/memory"
 )
 
 type testClientConn struct {
@@ -22,6 +24,10 @@ func (t *testClientConn) UpdateState(s resolver.State) error {
 	return nil
 }
 
+func (t *testClientConn) ReportError(err error) {
+	t.te.Log("ReportError", err)
+}
+
 type testWatch struct {
 	err error
 
@@ -125,3 +131,84 @@ func TestParseAttributes(t *testing.T) {
 		t.Errorf("expect nil, got %v", x.Value("notfound"))
 	}
 }
+
+type errorConn struct {
+	resolver.ClientConn
+
+	mu   sync.Mutex
+	errs []error
+}
+
+func (c *errorConn) ReportError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errs = append(c.errs, err)
+}
+
+type failingWatch struct{}
+
+func (failingWatch) Next() ([]*registry.ServiceInstance, error) {
+	return nil, errors.New("bad")
+}
+
+func (failingWatch) Stop() error { return nil }
+
+func TestWatchReportError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cc := &errorConn{}
+	r := &discoveryResolver{
+		w:      failingWatch{},
+		cc:     cc,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	time.AfterFunc(3*time.Second, r.Close)
+	start := time.Now()
+	r.watch()
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if len(cc.errs) == 0 {
+		t.Error("expect persistent failures reported")
+	}
+	// backoff instead of busy looping
+	if n := len(cc.errs); n > 3 {
+		t.Errorf("expect at most 3 reported errors, got %d", n)
+	}
+	if time.Since(start) < 3*time.Second {
+		t.Error("expect watch returned after close")
+	}
+}
+
+func TestResolveNow(t *testing.T) {
+	d := memory.New()
+	_ = d.Register(context.Background(), &registry.ServiceInstance{
+		ID: "1", Name: "helloworld", Endpoints: []string{"grpc://127.0.0.1:9000"},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cc := &stateConn{}
+	r := &discoveryResolver{
+		cc:          cc,
+		ctx:         ctx,
+		cancel:      cancel,
+		insecure:    true,
+		serviceName: "helloworld",
+		discoverer:  d,
+		timeout:     time.Second,
+		resolveNow:  make(chan struct{}, 1),
+	}
+	defer r.Close()
+	go r.refresh()
+
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	deadline := time.Now().Add(time.Second)
+	for {
+		if addr, _ := cc.last(); addr == "127.0.0.1:9000" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expect ResolveNow to refresh the addresses")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
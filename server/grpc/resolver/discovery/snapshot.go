@@ -0,0 +1,62 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/apus-run/gaea/registry"
+)
+
+// snapshot persists the last resolved instances of services on disk, one
+// JSON file per service, to serve them on cold starts when the discovery
+// is down.
+type snapshot struct {
+	dir string
+}
+
+func (s *snapshot) file(serviceName string) string {
+	return filepath.Join(s.dir, url.PathEscape(serviceName)+".json")
+}
+
+// load returns the instances of the service, or nil if there is no snapshot.
+func (s *snapshot) load(serviceName string) ([]*registry.ServiceInstance, error) {
+	b, err := os.ReadFile(s.file(serviceName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ins []*registry.ServiceInstance
+	if err := json.Unmarshal(b, &ins); err != nil {
+		return nil, err
+	}
+	return ins, nil
+}
+
+// save writes the instances of the service atomically.
+func (s *snapshot) save(serviceName string, ins []*registry.ServiceInstance) error {
+	b, err := json.Marshal(ins)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file(serviceName))
+}
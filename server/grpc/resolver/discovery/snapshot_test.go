@@ -0,0 +1,190 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+
+	"github.com/apus-run/gaea/registry"
+	"github.com/apus-run/gaea/registry/memory"
+)
+
+func TestSnapshot(t *testing.T) {
+	s := &snapshot{dir: t.TempDir()}
+	ins, err := s.load("helloworld")
+	if err != nil || ins != nil {
+		t.Fatalf("expect no snapshot, got %v, %v", ins, err)
+	}
+	want := []*registry.ServiceInstance{
+		{ID: "1", Name: "helloworld", Endpoints: []string{"grpc://127.0.0.1:9000"}},
+	}
+	if err = s.save("helloworld", want); err != nil {
+		t.Fatal(err)
+	}
+	ins, err = s.load("helloworld")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want, ins) {
+		t.Errorf("expect %v, got %v", want, ins)
+	}
+}
+
+// unavailableDiscovery fails to watch until it is available.
+type unavailableDiscovery struct {
+	*memory.Registry
+
+	mu        sync.Mutex
+	available bool
+}
+
+func (d *unavailableDiscovery) Watch(ctx context.Context, serviceName string) (registry.Watcher, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.available {
+		return nil, errors.New("discovery unavailable")
+	}
+	return d.Registry.Watch(ctx, serviceName)
+}
+
+// slowDiscovery blocks Watch until release is closed.
+type slowDiscovery struct {
+	*memory.Registry
+	release chan struct{}
+}
+
+func (d *slowDiscovery) Watch(ctx context.Context, serviceName string) (registry.Watcher, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-d.release:
+	}
+	return d.Registry.Watch(ctx, serviceName)
+}
+
+type stateConn struct {
+	resolver.ClientConn
+
+	mu     sync.Mutex
+	states []resolver.State
+}
+
+func (c *stateConn) UpdateState(s resolver.State) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.states = append(c.states, s)
+	return nil
+}
+
+func (c *stateConn) last() (string, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.states) == 0 {
+		return "", 0
+	}
+	s := c.states[len(c.states)-1]
+	return s.Addresses[0].Addr, len(c.states)
+}
+
+func TestBuilder_BuildFromSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	d := &unavailableDiscovery{Registry: memory.New()}
+	target := resolver.Target{URL: url.URL{Path: "/helloworld"}}
+
+	// no snapshot, Build fails
+	b := NewBuilder(d, WithInsecure(true), WithSnapshot(dir))
+	if _, err := b.Build(target, &stateConn{}, resolver.BuildOptions{}); err == nil {
+		t.Fatal("expect error without snapshot")
+	}
+
+	err := (&snapshot{dir: dir}).save("helloworld", []*registry.ServiceInstance{
+		{ID: "1", Name: "helloworld", Endpoints: []string{"grpc://127.0.0.1:9000"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// serve the snapshot immediately
+	cc := &stateConn{}
+	r, err := b.Build(target, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if addr, _ := cc.last(); addr != "127.0.0.1:9000" {
+		t.Fatalf("expect snapshot address, got %q", addr)
+	}
+
+	// replace the snapshot once the discovery is back
+	_ = d.Register(context.Background(), &registry.ServiceInstance{
+		ID: "2", Name: "helloworld", Endpoints: []string{"grpc://127.0.0.1:9001"},
+	})
+	d.mu.Lock()
+	d.available = true
+	d.mu.Unlock()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if addr, _ := cc.last(); addr == "127.0.0.1:9001" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expect live address")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	ins, err := (&snapshot{dir: dir}).load("helloworld")
+	if err != nil || len(ins) != 1 || ins[0].ID != "2" {
+		t.Errorf("expect snapshot updated, got %v, %v", ins, err)
+	}
+}
+
+func TestBuilder_SnapshotBeforeWatch(t *testing.T) {
+	dir := t.TempDir()
+	d := &slowDiscovery{Registry: memory.New(), release: make(chan struct{})}
+	target := resolver.Target{URL: url.URL{Path: "/helloworld"}}
+	err := (&snapshot{dir: dir}).save("helloworld", []*registry.ServiceInstance{
+		{ID: "1", Name: "helloworld", Endpoints: []string{"grpc://127.0.0.1:9000"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Build serves the snapshot without waiting for the watch timeout
+	b := NewBuilder(d, WithInsecure(true), WithSnapshot(dir), WithTimeout(time.Minute))
+	cc := &stateConn{}
+	start := time.Now()
+	r, err := b.Build(target, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Build() waited %v for the discovery", elapsed)
+	}
+	if addr, _ := cc.last(); addr != "127.0.0.1:9000" {
+		t.Fatalf("expect snapshot address, got %q", addr)
+	}
+
+	// the first instances of the watcher replace the snapshot
+	_ = d.Register(context.Background(), &registry.ServiceInstance{
+		ID: "2", Name: "helloworld", Endpoints: []string{"grpc://127.0.0.1:9001"},
+	})
+	close(d.release)
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if addr, _ := cc.last(); addr == "127.0.0.1:9001" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expect live address")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
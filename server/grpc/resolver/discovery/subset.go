@@ -0,0 +1,77 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+
+	"github.com/apus-run/gaea/registry"
+)
+
+// subset limits a client to a deterministic subset of the instances with
+// rendezvous hashing: every instance is scored by the hash of the client ID
+// and the instance ID, and the client keeps the instances with the highest
+// scores. When an instance joins or leaves, the subset of a client changes
+// by at most that instance, so churn does not reshuffle the other clients.
+// The clients are assigned to the instances at random, the connections are
+// spread evenly only on average.
+type subset struct {
+	clientID uint64
+	size     int
+}
+
+// newSubset creates the subset of the client, an empty client ID falls
+// back to a random one, so that the clients without an ID do not all share
+// the same subset. Such a subset is only stable for the life of the process.
+func newSubset(clientID string, size int) *subset {
+	if clientID == "" {
+		return &subset{clientID: rand.Uint64(), size: size}
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(clientID))
+	return &subset{clientID: h.Sum64(), size: size}
+}
+
+// score returns the rendezvous score of the instance for the client.
+func (s *subset) score(id string) uint64 {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], s.clientID)
+	h := fnv.New64a()
+	_, _ = h.Write(b[:])
+	_, _ = h.Write([]byte(id))
+	// fnv mixes the last bytes poorly, finish with the splitmix64 finalizer
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// apply returns the subset of ins of the client, ins is left untouched.
+func (s *subset) apply(ins []*registry.ServiceInstance) []*registry.ServiceInstance {
+	if s == nil || s.size <= 0 || len(ins) <= s.size {
+		return ins
+	}
+	type scored struct {
+		in    *registry.ServiceInstance
+		score uint64
+	}
+	all := make([]scored, len(ins))
+	for i, in := range ins {
+		all[i] = scored{in: in, score: s.score(in.ID)}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].score != all[j].score {
+			return all[i].score > all[j].score
+		}
+		return all[i].in.ID < all[j].in.ID
+	})
+	sub := make([]*registry.ServiceInstance, s.size)
+	for i := range sub {
+		sub[i] = all[i].in
+	}
+	return sub
+}
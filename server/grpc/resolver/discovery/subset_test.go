@@ -0,0 +1,121 @@
+package discovery
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/apus-run/gaea/registry"
+)
+
+func fleet(n int) []*registry.ServiceInstance {
+	ins := make([]*registry.ServiceInstance, 0, n)
+	for i := 0; i < n; i++ {
+		ins = append(ins, &registry.ServiceInstance{
+			ID:        fmt.Sprintf("instance-%03d", i),
+			Endpoints: []string{fmt.Sprintf("grpc://127.0.0.1:%d", 9000+i)},
+		})
+	}
+	return ins
+}
+
+func TestSubset(t *testing.T) {
+	ins := fleet(100)
+	s := newSubset("client-1", 10)
+
+	got := ids(s.apply(ins))
+	if len(got) != 10 {
+		t.Fatalf("expect 10 instances, got %d", len(got))
+	}
+	// deterministic regardless of the resolved order
+	reversed := make([]*registry.ServiceInstance, len(ins))
+	for i, in := range ins {
+		reversed[len(ins)-1-i] = in
+	}
+	if again := ids(newSubset("client-1", 10).apply(reversed)); !reflect.DeepEqual(got, again) {
+		t.Errorf("expect %v, got %v", got, again)
+	}
+	// ins is left untouched
+	if ins[0].ID != "instance-000" {
+		t.Error("expect the resolved instances untouched")
+	}
+
+	// no subsetting
+	var none *subset
+	if n := len(none.apply(ins)); n != 100 {
+		t.Errorf("expect 100 instances, got %d", n)
+	}
+	if n := len(newSubset("client-1", 200).apply(ins)); n != 100 {
+		t.Errorf("expect 100 instances, got %d", n)
+	}
+}
+
+func TestSubset_Spread(t *testing.T) {
+	ins := fleet(100)
+	conns := make(map[string]int)
+	const clients = 1000
+	for i := 0; i < clients; i++ {
+		for _, in := range newSubset(fmt.Sprintf("client-%d", i), 10).apply(ins) {
+			conns[in.ID]++
+		}
+	}
+	if len(conns) != 100 {
+		t.Fatalf("expect every instance used, got %d", len(conns))
+	}
+	// about 100 connections per instance
+	for id, n := range conns {
+		if n < 50 || n > 150 {
+			t.Errorf("unbalanced subsets, %s got %d connections", id, n)
+		}
+	}
+}
+
+func TestSubset_Churn(t *testing.T) {
+	ins := fleet(101)
+	for i := 0; i < 1000; i++ {
+		s := newSubset(fmt.Sprintf("client-%d", i), 10)
+		before := s.apply(ins[:100])
+		for name, after := range map[string][]*registry.ServiceInstance{
+			"join":  s.apply(ins),
+			"leave": s.apply(ins[1:100]),
+		} {
+			kept := 0
+			for _, a := range after {
+				for _, b := range before {
+					if a.ID == b.ID {
+						kept++
+					}
+				}
+			}
+			// an instance joining or leaving changes at most one instance
+			if kept < 9 {
+				t.Fatalf("client-%d: expect at least 9 instances kept on %s, got %d", i, name, kept)
+			}
+		}
+	}
+}
+
+func TestSubset_EmptyClientID(t *testing.T) {
+	ins := fleet(100)
+	// the clients without an ID do not all get the same subset
+	first := ids(newSubset("", 10).apply(ins))
+	for i := 0; i < 10; i++ {
+		if !reflect.DeepEqual(first, ids(newSubset("", 10).apply(ins))) {
+			return
+		}
+	}
+	t.Errorf("expect different subsets for empty client IDs, got %v", first)
+}
+
+func TestResolverSubset(t *testing.T) {
+	cc := &stateConn{}
+	r := &discoveryResolver{cc: cc, insecure: true, subset: newSubset("client-1", 2)}
+	if !r.updateState(testInstances()) {
+		t.Fatal("expect state updated")
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if n := len(cc.states[0].Addresses); n != 2 {
+		t.Errorf("expect 2 addresses, got %d", n)
+	}
+}
@@ -0,0 +1,75 @@
+package file
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+const name = "file"
+
+func init() {
+	resolver.Register(NewBuilder())
+}
+
+// Option is builder option.
+type Option func(b *fileBuilder)
+
+// WithPollInterval with the interval of checking the address file for changes.
+func WithPollInterval(interval time.Duration) Option {
+	return func(b *fileBuilder) {
+		b.pollInterval = interval
+	}
+}
+
+type fileBuilder struct {
+	pollInterval time.Duration
+}
+
+// NewBuilder creates a fileBuilder which is used to factory file resolvers.
+// The resolver reads the addresses from a local file and updates the
+// client conn when the file changes, so traffic can be moved without
+// restarting the clients.
+// example:
+//
+//	file:///etc/gaea/helloworld.addrs
+//
+// The file has one address per line, followed by optional key=value
+// metadata, such as the weight. Blank lines and lines starting with '#'
+// are ignored:
+//
+//	# helloworld
+//	127.0.0.1:9000 weight=10 zone=a
+//	127.0.0.2:9000 weight=5
+func NewBuilder(opts ...Option) resolver.Builder {
+	b := &fileBuilder{
+		pollInterval: time.Second,
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	return b
+}
+
+func (b *fileBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &fileResolver{
+		path:         target.URL.Path,
+		cc:           cc,
+		ctx:          ctx,
+		cancel:       cancel,
+		pollInterval: b.pollInterval,
+		resolveNow:   make(chan struct{}, 1),
+	}
+	if err := r.resolve(); err != nil {
+		cancel()
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+func (b *fileBuilder) Scheme() string {
+	return name
+}
@@ -0,0 +1,123 @@
+package file
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+func TestFileBuilder_Scheme(t *testing.T) {
+	b := NewBuilder()
+	if !reflect.DeepEqual(b.Scheme(), "file") {
+		t.Errorf("expect %v, got %v", "file", b.Scheme())
+	}
+}
+
+type mockConn struct {
+	resolver.ClientConn
+
+	mu     sync.Mutex
+	states []resolver.State
+	errs   []error
+}
+
+func (m *mockConn) UpdateState(s resolver.State) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states = append(m.states, s)
+	return nil
+}
+
+func (m *mockConn) ReportError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errs = append(m.errs, err)
+}
+
+func (m *mockConn) counts() (int, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.states), len(m.errs)
+}
+
+func (m *mockConn) addrs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var addrs []string
+	for _, a := range m.states[len(m.states)-1].Addresses {
+		addrs = append(addrs, a.Addr)
+	}
+	return addrs
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFileBuilder_Build(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "helloworld.addrs")
+	target := resolver.Target{URL: url.URL{Scheme: "file", Path: path}}
+	b := NewBuilder(WithPollInterval(20 * time.Millisecond))
+
+	// no file
+	if _, err := b.Build(target, &mockConn{}, resolver.BuildOptions{}); err == nil {
+		t.Fatal("expect error without address file")
+	}
+
+	if err := os.WriteFile(path, []byte("127.0.0.1:9000 weight=10\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cc := &mockConn{}
+	r, err := b.Build(target, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got := cc.addrs(); !reflect.DeepEqual(got, []string{"127.0.0.1:9000"}) {
+		t.Errorf("expect [127.0.0.1:9000], got %v", got)
+	}
+
+	// unchanged content does not update the state
+	time.Sleep(100 * time.Millisecond)
+	if n, _ := cc.counts(); n != 1 {
+		t.Errorf("expect 1 update, got %d", n)
+	}
+
+	// move traffic
+	if err = os.WriteFile(path, []byte("127.0.0.2:9000\n127.0.0.3:9000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool {
+		n, _ := cc.counts()
+		return n == 2
+	})
+	if got := cc.addrs(); !reflect.DeepEqual(got, []string{"127.0.0.2:9000", "127.0.0.3:9000"}) {
+		t.Errorf("expect new addresses, got %v", got)
+	}
+
+	// invalid content keeps the addresses and reports the error
+	if err = os.WriteFile(path, []byte("# empty\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	eventually(t, func() bool {
+		_, n := cc.counts()
+		return n > 0
+	})
+	if n, _ := cc.counts(); n != 2 {
+		t.Errorf("expect 2 updates, got %d", n)
+	}
+}
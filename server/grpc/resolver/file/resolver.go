@@ -0,0 +1,114 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+type fileResolver struct {
+	path string
+	cc   resolver.ClientConn
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	pollInterval time.Duration
+	resolveNow   chan struct{}
+
+	// last is the content of the file last resolved
+	last []byte
+}
+
+// watch resolves the file every poll interval or on ResolveNow, the client
+// conn is only updated when the content changed.
+func (r *fileResolver) watch() {
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.resolveNow:
+		}
+		if err := r.resolve(); err != nil {
+			// keep the addresses resolved before
+			r.cc.ReportError(err)
+		}
+	}
+}
+
+func (r *fileResolver) resolve() error {
+	b, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	if r.last != nil && bytes.Equal(b, r.last) {
+		return nil
+	}
+	addrs, err := parse(b)
+	if err != nil {
+		return fmt.Errorf("file resolver: %s: %w", r.path, err)
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("file resolver: %s: no address found", r.path)
+	}
+	if err = r.cc.UpdateState(resolver.State{Addresses: addrs}); err != nil {
+		return err
+	}
+	r.last = b
+	return nil
+}
+
+// parse parses the address file, the metadata of an address is attached
+// to its attributes like the discovery resolver does.
+func parse(b []byte) ([]resolver.Address, error) {
+	var addrs []resolver.Address
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		addr := resolver.Address{Addr: fields[0]}
+		var attrs *attributes.Attributes
+		for _, f := range fields[1:] {
+			k, v, ok := strings.Cut(f, "=")
+			if !ok || k == "" {
+				return nil, fmt.Errorf("line %d: invalid metadata %q", line, f)
+			}
+			if attrs == nil {
+				attrs = attributes.New(k, v)
+			} else {
+				attrs = attrs.WithValue(k, v)
+			}
+		}
+		addr.Attributes = attrs
+		addrs = append(addrs, addr)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
+
+func (r *fileResolver) Close() {
+	r.cancel()
+}
+
+// ResolveNow triggers an immediate read of the address file.
+func (r *fileResolver) ResolveNow(_ resolver.ResolveNowOptions) {
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,36 @@
+package file
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	addrs, err := parse([]byte(`
+# helloworld
+127.0.0.1:9000 weight=10 zone=a
+
+127.0.0.2:9000
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 {
+		t.Fatalf("expect 2 addresses, got %d", len(addrs))
+	}
+	if addrs[0].Addr != "127.0.0.1:9000" || addrs[1].Addr != "127.0.0.2:9000" {
+		t.Errorf("unexpected addresses %v", addrs)
+	}
+	if v := addrs[0].Attributes.Value("weight"); v != "10" {
+		t.Errorf("expect weight 10, got %v", v)
+	}
+	if v := addrs[0].Attributes.Value("zone"); v != "a" {
+		t.Errorf("expect zone a, got %v", v)
+	}
+	if addrs[1].Attributes != nil {
+		t.Errorf("expect no attributes, got %v", addrs[1].Attributes)
+	}
+
+	if _, err = parse([]byte("127.0.0.1:9000 weight")); err == nil {
+		t.Error("expect invalid metadata error")
+	}
+}
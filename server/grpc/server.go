@@ -18,7 +18,11 @@ import (
 	"github.com/apus-run/gaea/server"
 )
 
-var _ server.Server = (*Server)(nil)
+var (
+	_ server.Server  = (*Server)(nil)
+	_ server.Drainer = (*Server)(nil)
+	_ server.Readier = (*Server)(nil)
+)
 
 // NewServer creates a gRPC server by options.
 func NewServer(opts ...ServerOption) *Server {
@@ -90,9 +94,22 @@ func (s *Server) Start(ctx context.Context) error {
 	s.ctx = ctx
 	log.Infof("[gRPC] server listening on: %s", s.lis.Addr().String())
 	s.health.Resume()
+	s.readyOnce.Do(func() {
+		close(s.ready)
+	})
 	return s.Serve(s.lis)
 }
 
+// Ready returns a channel which is closed once the server is serving.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Drain sets the health of all services to not serving.
+func (s *Server) Drain() {
+	s.health.Shutdown()
+}
+
 // Stop stop the gRPC server.
 func (s *Server) Stop(_ context.Context) error {
 	if s.adminClean != nil {
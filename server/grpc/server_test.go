@@ -3,17 +3,23 @@ package grpc
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/url"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/apus-run/gaea/internal/matcher"
 	pb "github.com/apus-run/gaea/internal/testdata/helloworld"
 	"github.com/apus-run/gaea/middleware"
+	"github.com/apus-run/gaea/middleware/retry"
 )
 
 // service is used to implement helloworld.GreeterServer.
@@ -161,7 +167,7 @@ func TestServer_unaryServerInterceptor(t *testing.T) {
 	srv := &Server{
 		ctx:        context.Background(),
 		endpoint:   u,
-		timeout:    time.Duration(10),
+		timeout:    time.Second,
 		middleware: matcher.New(),
 	}
 	srv.middleware.Use(EmptyMiddleware())
@@ -177,3 +183,409 @@ func TestServer_unaryServerInterceptor(t *testing.T) {
 		t.Errorf("expect %s, got %s", "hi", rv.(*testResp).Data)
 	}
 }
+
+func TestServer_Ready(t *testing.T) {
+	srv := NewServer()
+	select {
+	case <-srv.Ready():
+		t.Fatal("expect server not ready before start")
+	default:
+	}
+	go func() {
+		_ = srv.Start(context.Background())
+	}()
+	select {
+	case <-srv.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("expect server ready after start")
+	}
+	_ = srv.Stop(context.Background())
+}
+
+type countingStream struct {
+	middleware.Stream
+	sent, recv *atomic.Int32
+}
+
+func (s *countingStream) SendMsg(m any) error {
+	s.sent.Add(1)
+	return s.Stream.SendMsg(m)
+}
+
+func (s *countingStream) RecvMsg(m any) error {
+	err := s.Stream.RecvMsg(m)
+	if err == nil {
+		s.recv.Add(1)
+	}
+	return err
+}
+
+func TestStreamMiddleware(t *testing.T) {
+	var serverSent, serverRecv, clientSent, clientRecv atomic.Int32
+	var serverOp, serverMD atomic.Value
+	serverEnd := make(chan error, 1)
+	srv := NewServer()
+	srv.Use("/helloworld.Greeter/*", func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			op, _ := middleware.OperationFromContext(ctx)
+			serverOp.Store(op)
+			if md, ok := metadata.FromIncomingContext(ctx); ok {
+				serverMD.Store(md.Get("x-md-stream"))
+			}
+			reply, err := handler(ctx, req)
+			if _, ok := middleware.StreamFromContext(ctx); ok {
+				serverEnd <- err
+			}
+			return reply, err
+		}
+	}, middleware.WrapStream(func(ctx context.Context, s middleware.Stream) middleware.Stream {
+		return &countingStream{Stream: s, sent: &serverSent, recv: &serverRecv}
+	}))
+	pb.RegisterGreeterServer(srv, &service{})
+	go func() {
+		_ = srv.Start(context.Background())
+	}()
+	<-srv.Ready()
+	defer srv.Stop(context.Background())
+
+	u, err := srv.Endpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientEnd := make(chan error, 1)
+	conn, err := DialInsecure(context.Background(), WithEndpoint(u.Host),
+		WithMiddleware(func(handler middleware.Handler) middleware.Handler {
+			return func(ctx context.Context, req any) (any, error) {
+				if _, ok := middleware.StreamFromContext(ctx); !ok {
+					return handler(ctx, req)
+				}
+				if op, _ := middleware.OperationFromContext(ctx); op == "/helloworld.Greeter/SayHelloStream" {
+					ctx = metadata.AppendToOutgoingContext(ctx, "x-md-stream", "1")
+				}
+				reply, err := handler(ctx, req)
+				clientEnd <- err
+				return reply, err
+			}
+		}, middleware.WrapStream(func(ctx context.Context, s middleware.Stream) middleware.Stream {
+			return &countingStream{Stream: s, sent: &clientSent, recv: &clientRecv}
+		})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewGreeterClient(conn)
+
+	stream, err := client.SayHelloStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if err = stream.Send(&pb.HelloRequest{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Message != "hello "+name {
+			t.Errorf("expect hello %s, got %s", name, reply.Message)
+		}
+	}
+	// the server ends the stream after 2 messages
+	if _, err = stream.Recv(); err != io.EOF {
+		t.Fatalf("expect EOF, got %v", err)
+	}
+
+	for name, ch := range map[string]chan error{"server": serverEnd, "client": clientEnd} {
+		select {
+		case err := <-ch:
+			if err != nil {
+				t.Errorf("expect the %s stream to end without error, got %v", name, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect the %s middleware to see the end of the stream", name)
+		}
+	}
+	if op := serverOp.Load(); op != "/helloworld.Greeter/SayHelloStream" {
+		t.Errorf("expect the stream operation, got %v", op)
+	}
+	if md := serverMD.Load(); !reflect.DeepEqual(md, []string{"1"}) {
+		t.Errorf("expect the metadata set by the client middleware, got %v", md)
+	}
+	if serverRecv.Load() != 2 || serverSent.Load() != 2 || clientSent.Load() != 2 || clientRecv.Load() != 2 {
+		t.Errorf("unexpected message counts, server %d/%d client %d/%d",
+			serverRecv.Load(), serverSent.Load(), clientSent.Load(), clientRecv.Load())
+	}
+}
+
+func TestStreamMiddleware_Reject(t *testing.T) {
+	errDenied := status.Error(codes.PermissionDenied, "denied")
+	c := ApplyClient(WithMiddleware(func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			return nil, errDenied
+		}
+	}))
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		t.Fatal("expect the stream not opened")
+		return nil, nil
+	}
+	_, err := c.streamClientInterceptor(c.ms)(context.Background(), &grpc.StreamDesc{}, &grpc.ClientConn{}, "/helloworld.Greeter/SayHelloStream", streamer)
+	if err != errDenied {
+		t.Errorf("expect %v, got %v", errDenied, err)
+	}
+}
+
+// finishingStreamer opens a fake stream which ends with err, it counts the
+// opened streams.
+func finishingStreamer(opens *atomic.Int32, err error) grpc.Streamer {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		opens.Add(1)
+		for _, o := range opts {
+			if f, ok := o.(grpc.OnFinishCallOption); ok {
+				f.OnFinish(err)
+			}
+		}
+		return &fakeClientStream{}, nil
+	}
+}
+
+type fakeClientStream struct {
+	grpc.ClientStream
+}
+
+func TestStreamMiddleware_Retry(t *testing.T) {
+	end := make(chan error, 1)
+	c := ApplyClient(WithMiddleware(
+		func(handler middleware.Handler) middleware.Handler {
+			return func(ctx context.Context, req any) (any, error) {
+				reply, err := handler(ctx, req)
+				end <- err
+				return reply, err
+			}
+		},
+		retry.Client(retry.WithDefaultPolicy(retry.Policy{
+			MaxAttempts: 3,
+			Codes:       []codes.Code{codes.Unavailable},
+		})),
+	))
+	var opens atomic.Int32
+	errReset := status.Error(codes.Unavailable, "reset")
+	cs, err := c.streamClientInterceptor(c.ms)(context.Background(), &grpc.StreamDesc{}, &grpc.ClientConn{},
+		"/helloworld.Greeter/SayHelloStream", finishingStreamer(&opens, errReset))
+	if err != nil || cs == nil {
+		t.Fatalf("expect the stream opened, got %v", err)
+	}
+	// the stream is not retried, its end reaches the middleware
+	select {
+	case err = <-end:
+		if err != errReset {
+			t.Errorf("expect %v, got %v", errReset, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the middleware to see the end of the stream")
+	}
+	if n := opens.Load(); n != 1 {
+		t.Errorf("expect 1 stream opened, got %d", n)
+	}
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamHandler_UnaryRetry(t *testing.T) {
+	srv := NewServer()
+	c := ApplyClient(WithMiddleware(retry.Client(retry.WithDefaultPolicy(retry.Policy{
+		MaxAttempts: 3,
+		Codes:       []codes.Code{codes.Unavailable},
+	}))))
+	var calls int
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return status.Error(codes.Unavailable, "")
+	}
+	// the stream handler makes a downstream unary call with its context
+	info := &grpc.StreamServerInfo{FullMethod: "/helloworld.Greeter/SayHelloStream", IsServerStream: true}
+	err := srv.streamServerInterceptor()(nil, &fakeServerStream{ctx: context.Background()}, info,
+		func(_ any, stream grpc.ServerStream) error {
+			return c.unaryClientInterceptor(c.ms, 0)(stream.Context(), "/helloworld.Greeter/SayHello", nil, nil,
+				&grpc.ClientConn{}, invoker)
+		})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expect Unavailable, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expect the unary call retried, got %d calls", calls)
+	}
+}
+
+func TestStreamMiddleware_OpenOnce(t *testing.T) {
+	second := make(chan error, 1)
+	c := ApplyClient(WithMiddleware(func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			_, _ = handler(ctx, req)
+			_, err := handler(ctx, req)
+			second <- err
+			return nil, err
+		}
+	}))
+	var opens atomic.Int32
+	cs, err := c.streamClientInterceptor(c.ms)(context.Background(), &grpc.StreamDesc{}, &grpc.ClientConn{},
+		"/helloworld.Greeter/SayHelloStream", finishingStreamer(&opens, nil))
+	if err != nil || cs == nil {
+		t.Fatalf("expect the stream opened, got %v", err)
+	}
+	select {
+	case err = <-second:
+		if err != errStreamOpened {
+			t.Errorf("expect %v, got %v", errStreamOpened, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the second call to return")
+	}
+	if n := opens.Load(); n != 1 {
+		t.Errorf("expect 1 stream opened, got %d", n)
+	}
+}
+
+func TestServer_Timeout(t *testing.T) {
+	srv := NewServer(
+		Timeout(50*time.Millisecond),
+		MethodTimeout("/helloworld.Greeter/SayHelloPost", time.Second),
+		MethodTimeout("/echo.Echo/*", 0),
+		MethodTimeout("/*", 2*time.Second),
+	)
+	background := func() (context.Context, context.CancelFunc) {
+		return context.Background(), func() {}
+	}
+	tests := []struct {
+		name     string
+		ctx      func() (context.Context, context.CancelFunc)
+		method   string
+		stream   bool
+		deadline time.Duration
+	}{
+		{"method", background, "/helloworld.Greeter/SayHelloPost", false, time.Second},
+		{"disabled", background, "/echo.Echo/Echo", false, 0},
+		{"stream", background, "/helloworld.Greeter/SayHelloStream", true, 2 * time.Second},
+		{"health watch", background, "/grpc.health.v1.Health/Watch", true, 2 * time.Second},
+		{"client deadline", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 5*time.Second)
+		}, "/helloworld.Greeter/SayHello", false, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent, cancel := tt.ctx()
+			defer cancel()
+			ctx, cancelTimeout := srv.withTimeout(parent, tt.method, tt.stream)
+			defer cancelTimeout()
+			deadline, ok := ctx.Deadline()
+			if tt.deadline == 0 {
+				if ok {
+					t.Fatalf("expect no deadline, got %v", time.Until(deadline))
+				}
+				return
+			}
+			if d := time.Until(deadline); !ok || d > tt.deadline || d < tt.deadline-100*time.Millisecond {
+				t.Errorf("expect a deadline in %v, got %v", tt.deadline, d)
+			}
+		})
+	}
+
+	// the server timeout only applies to the unary calls
+	srv = NewServer(Timeout(50 * time.Millisecond))
+	ctx, cancel := srv.withTimeout(context.Background(), "/helloworld.Greeter/SayHello", false)
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("expect the server timeout applied to the unary call")
+	}
+	ctx, cancel = srv.withTimeout(context.Background(), "/helloworld.Greeter/SayHelloStream", true)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("expect no deadline for the stream")
+	}
+	ctx, cancel = srv.withTimeout(context.Background(), "/grpc.health.v1.Health/Watch", true)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("expect no deadline for the health watch")
+	}
+}
+
+func TestServer_unaryServerInterceptorTimeout(t *testing.T) {
+	srv := NewServer(Timeout(10 * time.Millisecond))
+	info := &grpc.UnaryServerInfo{FullMethod: "/helloworld.Greeter/SayHello"}
+
+	// a handler returning ctx.Err()
+	_, err := srv.unaryServerInterceptor()(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expect DeadlineExceeded, got %v", err)
+	}
+
+	// a handler ignoring ctx
+	_, err = srv.unaryServerInterceptor()(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		time.Sleep(20 * time.Millisecond)
+		return &testResp{}, nil
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expect DeadlineExceeded, got %v", err)
+	}
+}
+
+type slowService struct {
+	pb.UnimplementedGreeterServer
+}
+
+func (s *slowService) SayHello(ctx context.Context, _ *pb.HelloRequest) (*pb.HelloReply, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (s *slowService) SayHelloStream(stream pb.Greeter_SayHelloStreamServer) error {
+	<-stream.Context().Done()
+	return stream.Context().Err()
+}
+
+func TestServer_TimeoutEndToEnd(t *testing.T) {
+	srv := NewServer(
+		Timeout(50*time.Millisecond),
+		MethodTimeout("/helloworld.Greeter/SayHelloStream", 50*time.Millisecond),
+	)
+	pb.RegisterGreeterServer(srv, &slowService{})
+	go func() {
+		_ = srv.Start(context.Background())
+	}()
+	<-srv.Ready()
+	defer srv.Stop(context.Background())
+
+	u, err := srv.Endpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := DialInsecure(context.Background(), WithEndpoint(u.Host), WithTimeout(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewGreeterClient(conn)
+
+	if _, err = client.SayHello(context.Background(), &pb.HelloRequest{Name: "gaea"}); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expect DeadlineExceeded, got %v", err)
+	}
+
+	stream, err := client.SayHelloStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stream.Recv(); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expect DeadlineExceeded, got %v", err)
+	}
+}
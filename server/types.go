@@ -15,6 +15,20 @@ type Server interface {
 	Endpoint() (*url.URL, error)
 }
 
+// Drainer is an optional interface of Server.
+// Drain flips the server health to not serving before it is deregistered
+// and stopped, so that no new traffic is routed to it.
+type Drainer interface {
+	Drain()
+}
+
+// Readier is an optional interface of Server.
+// Ready returns a channel which is closed once the server is serving,
+// the application waits on it before registering the service instance.
+type Readier interface {
+	Ready() <-chan struct{}
+}
+
 type (
 	grpcServerKey struct{}
 	grpcClientKey struct{}